@@ -0,0 +1,78 @@
+package asm
+
+import (
+	"strings"
+
+	"nfasm/isa"
+)
+
+const (
+    AstInstruction = iota
+    AstLabel
+    AstDeclaration
+    AstInclude
+)
+
+type Ast struct {
+    kind int
+    name, destination, source string
+    userStates uint16
+    span Span
+}
+
+func NewAst(kind int, name, destination, source string) Ast {
+    return Ast{kind, name, destination, source, 0, Span{}}
+}
+
+/* the source text of an ast, as the parser would read it back */
+func AstAsString(ast *Ast) string {
+    switch ast.kind {
+    case AstInstruction:
+	operands := []string{}
+
+	for _, operand := range []string{ast.destination, ast.source} {
+	    if operand != "<no value>" && operand != "" {
+		operands = append(operands, operand)
+	    }
+	}
+
+	if condition := isa.UserStatesAsString(ast.userStates); condition != "" {
+	    operands = append(operands, condition)
+	}
+
+	if len(operands) == 0 {
+	    return ast.name
+	}
+
+	return ast.name + " " + strings.Join(operands, ", ")
+
+    case AstLabel:
+	return ast.name + ":"
+
+    case AstDeclaration:
+	if ast.source == "String" {
+	    return ast.name + " \"" + ast.destination + "\""
+	}
+
+	return ast.name + " " + ast.destination
+
+    case AstInclude:
+	return "include \"" + ast.destination + "\""
+
+    default:
+	return ""
+    }
+}
+
+func (this *Ast) Span() Span {
+    return this.span
+}
+
+func (this *Ast) Kind() int {
+    return this.kind
+}
+
+/* the mnemonic of an instruction or declaration, the name of a label */
+func (this *Ast) Name() string {
+    return this.name
+}
@@ -0,0 +1,209 @@
+package asm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type CompileOptions struct {
+    Output string
+    Includes []string
+    Listing, SourceMap bool
+    Format string
+}
+
+func NewCompileOptions() CompileOptions {
+    return CompileOptions{"", nil, false, false, "raw"}
+}
+
+/* the source path without its extension, "./dir/a.b.s" gives "./dir/a.b", a path without one gets ".bin" appended */
+func OutputPath(path string) string {
+    extension := filepath.Ext(path)
+
+    if extension == "" || strings.ContainsAny(extension, "/\\") {
+	return path + ".bin"
+    }
+
+    return strings.TrimSuffix(path, extension)
+}
+
+/* parses path, splicing included files in place of their include */
+func ParseFile(path string, includes []string, visiting map[string]bool) ([]Ast, error) {
+    absolute, err := filepath.Abs(path)
+
+    if err != nil {
+	return nil, err
+    }
+
+    if visiting[absolute] {
+	return nil, errors.New("include cycle: " + path)
+    }
+
+    visiting[absolute] = true
+    defer delete(visiting, absolute)
+
+    buffer, err := ReadFile(path)
+
+    if err != nil {
+	return nil, err
+    }
+
+    return ParseSource(path, buffer, includes, visiting)
+}
+
+/* parses content without resolving its includes, path only names it in errors and spans */
+func ParseText(path, content string) ([]Ast, error) {
+    if len(content) == 0 {
+	return nil, nil
+    }
+
+    lexer := NewLexer(path, content)
+    parser, err := NewParser(&lexer)
+
+    if err != nil {
+	return nil, err
+    }
+
+    return parser.Parse()
+}
+
+/* parses the content of path, includes are resolved from the directory of path */
+func ParseSource(path, content string, includes []string, visiting map[string]bool) ([]Ast, error) {
+    tree, err := ParseText(path, content)
+
+    if err != nil {
+	return nil, err
+    }
+
+    var spliced []Ast
+
+    for _, ast := range tree {
+	if ast.kind != AstInclude {
+	    spliced = append(spliced, ast)
+	    continue
+	}
+
+	included, err := ResolveInclude(path, ast.destination, includes)
+
+	if err != nil {
+	    return nil, err
+	}
+
+	subtree, err := ParseFile(included, includes, visiting)
+
+	if err != nil {
+	    return nil, err
+	}
+
+	spliced = append(spliced, subtree...)
+    }
+
+    return spliced, nil
+}
+
+/* included paths are looked up next to the including file first, then in every include directory in order */
+func ResolveInclude(from, path string, includes []string) (string, error) {
+    if filepath.IsAbs(path) {
+	return path, nil
+    }
+
+    for _, directory := range append([]string{filepath.Dir(from)}, includes...) {
+	candidate := filepath.Join(directory, path)
+
+	if _, err := os.Stat(candidate); err == nil {
+	    return candidate, nil
+	}
+    }
+
+    return "", errors.New("include not found: " + path)
+}
+
+func Compile(path string, options CompileOptions) error {
+    if options.Format != "raw" && options.Format != "hex" {
+	return errors.New("unsupported output format: " + options.Format)
+    }
+
+    program, err := AssembleFile(path, options.Includes)
+
+    if err != nil {
+	return err
+    }
+
+    generation, tree := program.Words(), program.Tree()
+
+    if options.Listing {
+	WriteListing(os.Stdout, tree, generation)
+    }
+
+    output := options.Output
+
+    if output == "" {
+	output = OutputPath(path)
+    }
+
+    file, err := os.Create(output)
+
+    if err != nil {
+	return err
+    }
+
+    defer file.Close()
+
+    if options.SourceMap {
+	if err := WriteSourceMap(output + ".map", tree); err != nil {
+	    return err
+	}
+    }
+
+    if options.Format == "hex" {
+	for _, word := range generation {
+	    if _, err := fmt.Fprintf(file, "%04x\n", word); err != nil {
+		return err
+	    }
+	}
+
+	return nil
+    }
+
+    return binary.Write(file, binary.LittleEndian, generation)
+}
+
+/* prints every address, the words generated for it and the source they come from */
+func WriteListing(writer io.Writer, tree []Ast, generation []uint16) {
+    var address uint16
+
+    for _, ast := range tree {
+	if ast.kind == AstLabel {
+	    fmt.Fprintf(writer, "%04x:%-24s %s\n", address, "", AstAsString(&ast))
+	    continue
+	}
+
+	size := CalculateSyntaxSize(&ast)
+	words := []string{}
+
+	for index := address; index < address + size && int(index) < len(generation); index++ {
+	    words = append(words, fmt.Sprintf("%04x", generation[index]))
+	}
+
+	fmt.Fprintf(writer, "%04x: %-24s     %s\n", address, strings.Join(words, " "), AstAsString(&ast))
+	address += size
+    }
+}
+
+func WriteSourceMap(path string, tree []Ast) error {
+    file, err := os.Create(path)
+
+    if err != nil {
+	return err
+    }
+
+    defer file.Close()
+
+    sourceMap := BuildSourceMap(tree)
+    return sourceMap.Write(file)
+}
@@ -1,13 +1,15 @@
-package main
+package asm
 
 import (
-	"fmt"
+	"errors"
 	"strconv"
+
+	"nfasm/isa"
 )
 
 func ReferenceLabel(labels *[]Label, source string) *Label {
     for _, label := range *labels {
-	if label.name == source {
+	if label.Name == source {
 	    return &label
 	}
     }
@@ -33,7 +35,9 @@ func CalculateSyntaxSize(ast *Ast) uint16 {
 	break
 
     case AstDeclaration:
-	if ast.source == "String" {
+	if ast.source == "String" && ast.name == "dbp" {
+	    size = uint16(len(ast.destination) + 1) / 2
+	} else if ast.source == "String" {
 	    size = uint16(len(ast.destination))
 	} else if ast.source == "Integer" {
 	    size = 1
@@ -50,7 +54,12 @@ func CalculateSyntaxSize(ast *Ast) uint16 {
 }
 
 func CollectLabels(tree *[]Ast, labels *[]Label) {
-    var generationSize uint16
+    CollectLabelsAt(tree, labels, 0)
+}
+
+/* collects the labels of a tree generated at origin rather than at the start of the text segment */
+func CollectLabelsAt(tree *[]Ast, labels *[]Label, origin uint16) {
+    generationSize := origin
 
     for _, ast := range *tree {
 	if ast.kind == AstLabel {
@@ -62,57 +71,63 @@ func CollectLabels(tree *[]Ast, labels *[]Label) {
 }
 
 func Generate(tree []Ast) ([]uint16, error) {
-    var generation []uint16
     var labels []Label
+    return GenerateAt(tree, 0, &labels)
+}
+
+/* generates a tree placed at origin, its labels are added to labels, which may already hold earlier ones */
+func GenerateAt(tree []Ast, origin uint16, labels *[]Label) ([]uint16, error) {
+    var generation []uint16
 
-    CollectLabels(&tree, &labels)
+    CollectLabelsAt(&tree, labels, origin)
 
     for _, ast := range tree {
 	switch ast.kind {
 	case AstInstruction:
-	    opcode, err := OpcodeAsInt(ast.name)
+	    ast = LowerPseudoInstruction(ast)
+	    opcode, err := isa.OpcodeAsInt(ast.name)
 
 	    if err != nil {
-		return generation, err
+		return generation, NewSourceError(ast.span, err)
 	    }
 
 	    generation = append(generation, opcode)
 	    generation = append(generation, ast.userStates)
 
 	    if ast.destination != "<no value>" {
-		register, err := RegisterAsInt(ast.destination)
+		register, err := isa.RegisterAsInt(ast.destination)
 
 		if err != nil {
-		    return generation, err
+		    return generation, NewSourceError(ast.span, err)
 		}
 
 		generation = append(generation, register)
 	    }
 
 	    if ast.source != "<no value>" {
-		if ast.userStates & UserStateImmediate != 0x0000 {
+		if ast.userStates & isa.UserStateImmediate != 0x0000 {
 		    convert, err := strconv.ParseUint(ast.source, 10, 16)
 
 		    if err != nil {
-			return generation, err
+			return generation, NewSourceError(ast.span, err)
 		    }
 
 		    generation = append(generation, uint16(convert))
 		} else {
-		    register, err := RegisterAsInt(ast.source)
+		    register, err := isa.RegisterAsInt(ast.source)
 
 		    if err != nil {
-			label := ReferenceLabel(&labels, ast.source)
+			label := ReferenceLabel(labels, ast.source)
 
 			if label != nil {
 			    if ast.destination != "<no value>" {
-				generation[len(generation) - 2] |= UserStateImmediate
+				generation[len(generation) - 2] |= isa.UserStateImmediate
 			    } else {
-				generation[len(generation) - 1] |= UserStateImmediate
+				generation[len(generation) - 1] |= isa.UserStateImmediate
 			    }
-			    generation = append(generation, label.address)
+			    generation = append(generation, label.Address)
 			} else {
-			    fmt.Println("NOT IMPLEMENTED: label not found: " + ast.source)
+			    return generation, NewSourceError(ast.span, errors.New("label not found: " + ast.source))
 			}
 		    } else {
 			generation = append(generation, register)
@@ -126,14 +141,27 @@ func Generate(tree []Ast) ([]uint16, error) {
 	    break
 
 	case AstDeclaration:
-	    if ast.userStates & UserStateImmediate != 0x0000 {
+	    if ast.userStates & isa.UserStateImmediate != 0x0000 {
 		convert, err := strconv.ParseUint(ast.destination, 10, 16)
 
 		if err != nil {
-		    return generation, err
+		    return generation, NewSourceError(ast.span, err)
 		}
 
 		generation = append(generation, uint16(convert))
+	    } else if ast.name == "dbp" {
+		/* packed, two bytes per word, the low one first */
+		bytes := []byte(ast.destination)
+
+		for index := 0; index < len(bytes); index += 2 {
+		    word := uint16(bytes[index])
+
+		    if index + 1 < len(bytes) {
+			word |= uint16(bytes[index + 1]) << 8
+		    }
+
+		    generation = append(generation, word)
+		}
 	    } else {
 		bytes := []byte(ast.destination)
 
@@ -1,8 +1,6 @@
-package main
+package asm
 
-import (
-	"os"
-)
+import "os"
 
 func ReadFile(path string) (string, error) {
     file, err := os.Open(path)
@@ -1,8 +1,8 @@
-package main
+package asm
 
 type Label struct {
-    name string
-    address uint16
+    Name string
+    Address uint16
 }
 
 func NewLabel(name string, address uint16) Label {
@@ -1,4 +1,4 @@
-package main
+package asm
 
 type Lexer struct {
     content string
@@ -105,11 +105,20 @@ func (this *Lexer) LexEndOfFile() (Token, error) {
     return NewToken(TokenEndOfFile, "<EndOfFile>", this.span), nil
 }
 
+/* comments run from // to the end of the line */
 func (this *Lexer) SkipWhitespace() byte {
-    for this.current == ' ' || this.current == '\t' || this.current == '\n' {
+    for this.current == ' ' || this.current == '\t' || this.current == '\n' || this.IsComment() {
+	if this.IsComment() {
+	    for this.current != '\n' && this.current != 0 {
+		this.Advance()
+	    }
+
+	    continue
+	}
+
 	if this.current == '\n' {
-	    this.span.row++
-	    this.span.column = 0
+	    this.span.Row++
+	    this.span.Column = 0
 	}
 
 	this.Advance()
@@ -118,19 +127,23 @@ func (this *Lexer) SkipWhitespace() byte {
     return this.current
 }
 
+func (this *Lexer) IsComment() bool {
+    return this.current == '/' && this.span.Index+1 < uint64(len(this.content)) && this.content[this.span.Index+1] == '/'
+}
+
 func (this *Lexer) AdvanceWithToken(token Token) Token {
     this.Advance()
     return token
 }
 
 func (this *Lexer) Advance() byte {
-    this.span.column++
-    this.span.index++
+    this.span.Column++
+    this.span.Index++
     
-    if this.span.index == uint64(len(this.content)) {
+    if this.span.Index == uint64(len(this.content)) {
 	this.current = 0
     } else {
-	this.current = this.content[this.span.index]
+	this.current = this.content[this.span.Index]
     }
 
     return this.current
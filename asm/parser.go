@@ -1,7 +1,9 @@
-package main
+package asm
 
 import (
 	"errors"
+
+	"nfasm/isa"
 )
 
 type Parser struct {
@@ -34,23 +36,32 @@ func (this *Parser) ParseNext() (Ast, error) {
 		return this.ParseIdentifier()
 
 	default:
-		return NewAst(0, "", "", ""), nil
+		return NewAst(0, "", "", ""), NewSourceError(this.current.span, errors.New("unexpected token: "+this.current.value))
 	}
 }
 
 func (this *Parser) ParseIdentifier() (Ast, error) {
-	if this.IsInstruction() {
-		return this.ParseInstruction()
+	var ast Ast
+	var err error
+	span := this.current.span
+
+	if this.current.value == "include" {
+		ast, err = this.ParseInclude()
+	} else if this.IsInstruction() {
+		ast, err = this.ParseInstruction()
 	} else if this.IsDeclarator() {
-		return this.ParseDeclaration()
+		ast, err = this.ParseDeclaration()
 	} else {
-		return this.ParseName()
+		ast, err = this.ParseName()
 	}
+
+	ast.span = span
+	return ast, err
 }
 
 func (this *Parser) IsInstruction() bool {
-	for index := range OpcodeCount {
-		if opcode, err := OpcodeAsString(uint16(index)); err != nil {
+	for index := range isa.OpcodeCount {
+		if opcode, err := isa.OpcodeAsString(uint16(index)); err != nil {
 			return false
 		} else {
 			if this.current.value == opcode {
@@ -59,7 +70,7 @@ func (this *Parser) IsInstruction() bool {
 		}
 	}
 
-	return false
+	return PseudoInstructionFromString(this.current.value) != nil
 }
 
 func (this *Parser) ParseInstruction() (Ast, error) {
@@ -67,7 +78,7 @@ func (this *Parser) ParseInstruction() (Ast, error) {
 	var err error
 	var parsed bool
 
-	for _, value := range []string{"nop", "syscall", "ret"} {
+	for _, value := range []string{"nop", "syscall", "ret", "iret", "cli", "sti"} {
 		if this.current.value == value {
 			ast, err = this.ParseNoArged()
 			parsed = true
@@ -75,7 +86,7 @@ func (this *Parser) ParseInstruction() (Ast, error) {
 		}
 	}
 
-	for _, value := range []string{"not", "jmp", "jmpl", "push", "las", "inc", "dec"} {
+	for _, value := range []string{"not", "jmp", "jmpl", "push", "las", "inc", "dec", "int"} {
 		if this.current.value == value && !parsed {
 			ast, err = this.ParseOneArgedSource()
 			parsed = true
@@ -83,6 +94,11 @@ func (this *Parser) ParseInstruction() (Ast, error) {
 		}
 	}
 
+	if pseudo := PseudoInstructionFromString(this.current.value); pseudo != nil && !parsed {
+		ast, err = this.ParseOneArgedSource()
+		parsed = true
+	}
+
 	for _, value := range []string{"pop"} {
 		if this.current.value == value && !parsed {
 			ast, err = this.ParseOneArgedDestination()
@@ -91,23 +107,35 @@ func (this *Parser) ParseInstruction() (Ast, error) {
 		}
 	}
 
-	for _, value := range []string{"mov", "add", "sub", "mul", "div", "rem", "or", "xor", "and", "la", "str", "cmp"} {
+	for _, value := range []string{"mov", "add", "sub", "mul", "div", "rem", "or", "xor", "and", "la", "str", "cmp", "vld", "vst"} {
 		if this.current.value == value && !parsed {
 			ast, err = this.ParseTwoArged()
 			break
 		}
 	}
 
+	if err != nil {
+		return ast, err
+	}
+
 	if this.current.kind == TokenComma {
 		if _, err := this.Eat([]int{TokenComma}); err != nil {
 			return ast, err
 		}
 
-		if this.IsUserState() {
-			ast.userStates |= this.GetUserState()
+		if !this.IsUserState() {
+			return ast, NewSourceError(this.current.span, errors.New("invalid condition: "+this.current.value))
+		}
+
+		if pseudo := PseudoInstructionFromString(ast.name); pseudo != nil && pseudo.userStates != isa.UserStateDefault {
+			return ast, NewSourceError(this.current.span, errors.New("conditional instruction cannot take a condition: "+ast.name))
 		}
 
-		this.Eat([]int{TokenIdentifier})
+		ast.userStates |= this.GetUserState()
+
+		if _, err := this.Eat([]int{TokenIdentifier}); err != nil {
+			return ast, err
+		}
 	}
 
 	if ast.destination == "" {
@@ -213,7 +241,7 @@ func (this *Parser) ParseTwoArged() (Ast, error) {
 
 func (this *Parser) IsUserState() bool {
 	if this.current.kind == TokenIdentifier {
-		for _, value := range []string{"eq", "ne", "gt", "lt", "z", "nz", "c", "o"} {
+		for _, value := range []string{"eq", "ne", "gt", "lt", "ge", "le", "z", "nz", "c", "o"} {
 			if this.current.value == value {
 				return true
 			}
@@ -229,7 +257,7 @@ func (this *Parser) GetUserState() uint16 {
 	    return 0x0002
 
 	case "ne":
-	    return 0x0002
+	    return 0x0002 | isa.UserStateNegated
 
 	case "gt":
 	    return 0x0004
@@ -237,11 +265,17 @@ func (this *Parser) GetUserState() uint16 {
 	case "lt":
 	    return 0x0008
 
+	case "ge":
+	    return 0x0004 | 0x0002
+
+	case "le":
+	    return 0x0008 | 0x0002
+
 	case "z":
 	    return 0x0002
 
 	case "nz":
-	    return 0x0002
+	    return 0x0002 | isa.UserStateNegated
 
 	case "c":
 	    return 0x0004
@@ -250,12 +284,12 @@ func (this *Parser) GetUserState() uint16 {
 	    return 0x0008
 
 	default:
-	    return UserStateDefault
+	    return isa.UserStateDefault
 	}
 }
 
 func (this *Parser) IsDeclarator() bool {
-	for _, value := range []string{"db", "dw"} {
+	for _, value := range []string{"db", "dbp", "dw"} {
 		if this.current.value == value {
 			return true
 		}
@@ -287,12 +321,27 @@ func (this *Parser) ParseDeclaration() (Ast, error) {
 	ast.destination = value.value
 
 	if value.kind == TokenInteger {
-		ast.userStates |= UserStateImmediate
+		ast.userStates |= isa.UserStateImmediate
 	}
 
 	return ast, err
 }
 
+/* include "path", resolved by the compiler, see compiler.go */
+func (this *Parser) ParseInclude() (Ast, error) {
+	if _, err := this.Eat([]int{TokenIdentifier}); err != nil {
+		return NewAst(0, "", "", ""), err
+	}
+
+	path, err := this.Eat([]int{TokenString})
+
+	if err != nil {
+		return NewAst(0, "", "", ""), err
+	}
+
+	return NewAst(AstInclude, "include", path.value, ""), nil
+}
+
 func (this *Parser) ParseName() (Ast, error) {
 	var ast Ast
 	var err error
@@ -320,5 +369,5 @@ func (this *Parser) Eat(tokenKinds []int) (Token, error) {
 		}
 	}
 
-	return NewToken(0, "", NewSpan("", 0, 0, 0, 0)), errors.New("unexpected token: " + this.current.value)
+	return NewToken(0, "", NewSpan("", 0, 0, 0, 0)), NewSourceError(this.current.span, errors.New("unexpected token: "+this.current.value))
 }
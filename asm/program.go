@@ -0,0 +1,70 @@
+package asm
+
+import "errors"
+
+/*
+/
+/ Programs:
+/	Assemble and AssembleFile turn source into a Program, the words to load into a vm.CPU together with the tree they
+/	were generated from and its source map, so embedders don't have to go through files, Assemble refuses includes,
+/	only AssembleFile reads files from the host:
+/
+/		program, err := asm.Assemble("mov a, 1\nmov b, 0\nsyscall\n")
+/		cpu.LoadProgramFromMemory(program.Words())
+/
+*/
+
+type Program struct {
+	words     []uint16
+	tree      []Ast
+	sourceMap SourceMap
+}
+
+func NewProgram(tree []Ast) (Program, error) {
+	words, err := Generate(tree)
+
+	if err != nil {
+		return Program{}, err
+	}
+
+	return Program{words, tree, BuildSourceMap(tree)}, nil
+}
+
+func (this *Program) Words() []uint16 {
+	return this.words
+}
+
+func (this *Program) Tree() []Ast {
+	return this.tree
+}
+
+func (this *Program) SourceMap() *SourceMap {
+	return &this.sourceMap
+}
+
+/* assembles source held in memory, it may not include anything, so untrusted source never reads a host file */
+func Assemble(source string) (Program, error) {
+	tree, err := ParseText("<source>", source)
+
+	if err != nil {
+		return Program{}, err
+	}
+
+	for _, ast := range tree {
+		if ast.kind == AstInclude {
+			return Program{}, NewSourceError(ast.span, errors.New("include is not supported in memory, use AssembleFile"))
+		}
+	}
+
+	return NewProgram(tree)
+}
+
+func AssembleFile(path string, includes []string) (Program, error) {
+	tree, err := ParseFile(path, includes, map[string]bool{})
+
+	if err != nil {
+		return Program{}, err
+	}
+
+	return NewProgram(tree)
+}
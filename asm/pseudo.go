@@ -0,0 +1,61 @@
+package asm
+
+import (
+	"nfasm/isa"
+)
+
+type PseudoInstruction struct {
+	name, opcode string
+	userStates   uint16
+}
+
+/*
+/
+/ Pseudo instructions:
+/	aliases the generator lowers to existing opcodes, optionally marked with user states
+/	conditional ones cannot take an extra user states mark, "je label, c" is an error
+/
+/	je/jz   -> jmp, eq          jne/jnz -> jmp, ne
+/	jg      -> jmp, gt          jl      -> jmp, lt
+/	jge     -> jmp, ge          jle     -> jmp, le
+/	jc      -> jmp, c           jo      -> jmp, o
+/	call    -> jmpl             (accepts a user states mark, "call puts, eq")
+/
+*/
+
+var PseudoInstructions = []PseudoInstruction{
+	{"je", "jmp", isa.UserStateZero},
+	{"jz", "jmp", isa.UserStateZero},
+	{"jne", "jmp", isa.UserStateZero | isa.UserStateNegated},
+	{"jnz", "jmp", isa.UserStateZero | isa.UserStateNegated},
+	{"jg", "jmp", isa.UserStateCarry},
+	{"jl", "jmp", isa.UserStateOverflow},
+	{"jge", "jmp", isa.UserStateCarry | isa.UserStateZero},
+	{"jle", "jmp", isa.UserStateOverflow | isa.UserStateZero},
+	{"jc", "jmp", isa.UserStateCarry},
+	{"jo", "jmp", isa.UserStateOverflow},
+	{"call", "jmpl", isa.UserStateDefault},
+}
+
+func PseudoInstructionFromString(name string) *PseudoInstruction {
+	for index := range PseudoInstructions {
+		if PseudoInstructions[index].name == name {
+			return &PseudoInstructions[index]
+		}
+	}
+
+	return nil
+}
+
+/* rewrites a pseudo instruction ast into the instruction it stands for, other asts are returned untouched */
+func LowerPseudoInstruction(ast Ast) Ast {
+	pseudo := PseudoInstructionFromString(ast.name)
+
+	if pseudo == nil {
+		return ast
+	}
+
+	ast.name = pseudo.opcode
+	ast.userStates |= pseudo.userStates
+	return ast
+}
@@ -0,0 +1,186 @@
+package asm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SourceLocation struct {
+	Address, Size uint16
+	Span          Span
+	Text          string
+	Data          bool // a declaration rather than an instruction
+}
+
+/*
+/
+/ Source maps:
+/	map program addresses back to the source they were generated from, and name them with the labels of the program
+/
+/	"com --map" writes one next to the program (<program>.map), exe loads it when it exists, it is plain text:
+/		label <address> <name>
+/		line <address> <size> <row> <column> <path>\t<source text>
+/		data <address> <size> <row> <column> <path>\t<source text>
+/
+/	data entries are declarations (db, dbp, dw), they take addresses but are never executed
+/
+*/
+
+type SourceMap struct {
+	locations []SourceLocation
+	labels    []Label
+}
+
+/* addresses are computed the way CollectLabels does, so they match the generation of the same tree */
+func BuildSourceMap(tree []Ast) SourceMap {
+	var sourceMap SourceMap
+	var address uint16
+
+	CollectLabels(&tree, &sourceMap.labels)
+
+	for _, ast := range tree {
+		size := CalculateSyntaxSize(&ast)
+
+		if size != 0 {
+			sourceMap.locations = append(sourceMap.locations, SourceLocation{address, size, ast.span, AstAsString(&ast), ast.kind == AstDeclaration})
+		}
+
+		address += size
+	}
+
+	return sourceMap
+}
+
+func (this *SourceMap) Lookup(address uint16) *SourceLocation {
+	index := sort.Search(len(this.locations), func(index int) bool {
+		return this.locations[index].Address+this.locations[index].Size > address
+	})
+
+	if index < len(this.locations) && this.locations[index].Address <= address {
+		return &this.locations[index]
+	}
+
+	return nil
+}
+
+/* the closest label at or before address, the one a routine is usually named after */
+func (this *SourceMap) EnclosingLabel(address uint16) *Label {
+	var enclosing *Label
+
+	for index := range this.labels {
+		if this.labels[index].Address <= address && (enclosing == nil || this.labels[index].Address >= enclosing.Address) {
+			enclosing = &this.labels[index]
+		}
+	}
+
+	return enclosing
+}
+
+func (this *SourceMap) Locations() []SourceLocation {
+	return this.locations
+}
+
+func (this *SourceMap) Labels() []Label {
+	return this.labels
+}
+
+func (this *SourceMap) Label(name string) *Label {
+	return ReferenceLabel(&this.labels, name)
+}
+
+/* "path:row:column (label+offset)", or the bare address when nothing maps it */
+func (this *SourceMap) Describe(address uint16) string {
+	description := fmt.Sprintf("%d", address)
+
+	if location := this.Lookup(address); location != nil {
+		description = location.Span.String()
+	}
+
+	if label := this.EnclosingLabel(address); label != nil {
+		description += fmt.Sprintf(" (%s+%d)", label.Name, address-label.Address)
+	}
+
+	return description
+}
+
+func (this *SourceMap) Write(writer io.Writer) error {
+	buffered := bufio.NewWriter(writer)
+
+	for _, label := range this.labels {
+		fmt.Fprintf(buffered, "label %d %s\n", label.Address, label.Name)
+	}
+
+	for _, location := range this.locations {
+		kind := "line"
+
+		if location.Data {
+			kind = "data"
+		}
+
+		fmt.Fprintf(buffered, "%s %d %d %d %d %s\t%s\n", kind, location.Address, location.Size, location.Span.Row, location.Span.Column, location.Span.Stream, location.Text)
+	}
+
+	return buffered.Flush()
+}
+
+func ReadSourceMap(path string) (*SourceMap, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var sourceMap SourceMap
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if err := sourceMap.ParseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sourceMap, scanner.Err()
+}
+
+/* adds a line written by Write to the map */
+func (this *SourceMap) ParseLine(line string) error {
+	fields := strings.SplitN(line, " ", 6)
+
+	switch {
+	case len(fields) == 3 && fields[0] == "label":
+		address, err := strconv.ParseUint(fields[1], 10, 16)
+
+		if err != nil {
+			return err
+		}
+
+		this.labels = append(this.labels, NewLabel(fields[2], uint16(address)))
+
+	case len(fields) == 6 && (fields[0] == "line" || fields[0] == "data"):
+		var numbers [4]uint64
+		var err error
+
+		for index := range numbers {
+			if numbers[index], err = strconv.ParseUint(fields[index+1], 10, 64); err != nil {
+				return err
+			}
+		}
+
+		path, text, _ := strings.Cut(fields[5], "\t")
+		span := NewSpan(path, 0, numbers[2], numbers[3], 0)
+		this.locations = append(this.locations, SourceLocation{uint16(numbers[0]), uint16(numbers[1]), span, text, fields[0] == "data"})
+
+	default:
+		return errors.New("invalid source map line: " + line)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package asm
+
+import "fmt"
+
+type Span struct {
+    Stream string
+    Index, Row, Column, Length uint64
+}
+
+func NewSpan(stream string, index, row, column, length uint64) Span {
+    return Span{stream, index, row, column, length}
+}
+
+func (this *Span) WithLength(length uint64) *Span {
+    this.Length = length
+    return this
+}
+
+func (this *Span) String() string {
+    return fmt.Sprintf("%s:%d:%d", this.Stream, this.Row, this.Column)
+}
+
+/* an error located in the source, printed as path:row:column: message */
+type SourceError struct {
+    span Span
+    err error
+}
+
+func NewSourceError(span Span, err error) *SourceError {
+    return &SourceError{span, err}
+}
+
+func (this *SourceError) Error() string {
+    return this.span.String() + ": " + this.err.Error()
+}
+
+func (this *SourceError) Unwrap() error {
+    return this.err
+}
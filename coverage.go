@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
+)
+
+/*
+/
+/ Coverage:
+/	"exe --coverage file" records how many times every instruction address was reached and, for conditional
+/	instructions, how many times their user states let them execute (taken) or not (skipped)
+/
+/	the file is plain text and carries the source map of the program after the counts, so "nfasm cover" needs nothing else:
+/		hit <address> <count>
+/		branch <address> <taken> <skipped>
+/		label ... / line ... / data ... (see sourcemap.go)
+/
+/	a line is covered when one of its instructions was reached, a conditional line is partial until it was both taken
+/	and skipped
+/
+*/
+
+type Coverage struct {
+	hits      map[uint16]uint64
+	branches  map[uint16]*[2]uint64
+	sourceMap *asm.SourceMap
+}
+
+/* sourceMap is written along the counts, cover needs it */
+func NewCoverage(sourceMap *asm.SourceMap) *Coverage {
+	return &Coverage{make(map[uint16]uint64), make(map[uint16]*[2]uint64), sourceMap}
+}
+
+func (this *Coverage) Observe(cpu *vm.CPU, record *vm.StepRecord) {
+	/* the cycle stopped before its instruction was decoded */
+	if record.Opcode >= isa.OpcodeCount || !record.Executed && record.Err != nil {
+		return
+	}
+
+	this.hits[record.IP]++
+
+	if !record.Conditional {
+		return
+	}
+
+	if this.branches[record.IP] == nil {
+		this.branches[record.IP] = &[2]uint64{}
+	}
+
+	if record.Executed {
+		this.branches[record.IP][0]++
+	} else {
+		this.branches[record.IP][1]++
+	}
+}
+
+func SortedAddresses[V any](values map[uint16]V) []uint16 {
+	addresses := make([]uint16, 0, len(values))
+
+	for address := range values {
+		addresses = append(addresses, address)
+	}
+
+	sort.Slice(addresses, func(left, right int) bool { return addresses[left] < addresses[right] })
+	return addresses
+}
+
+func (this *Coverage) Write(writer io.Writer) error {
+	buffered := bufio.NewWriter(writer)
+
+	for _, address := range SortedAddresses(this.hits) {
+		fmt.Fprintf(buffered, "hit %d %d\n", address, this.hits[address])
+	}
+
+	for _, address := range SortedAddresses(this.branches) {
+		fmt.Fprintf(buffered, "branch %d %d %d\n", address, this.branches[address][0], this.branches[address][1])
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	if this.sourceMap != nil {
+		return this.sourceMap.Write(writer)
+	}
+
+	return nil
+}
+
+func (this *Coverage) WriteFile(path string) error {
+	file, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := this.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func ReadCoverage(path string) (*Coverage, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	coverage := NewCoverage(&asm.SourceMap{})
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		var numbers []uint64
+
+		if len(fields) != 0 && (fields[0] == "hit" || fields[0] == "branch") {
+			for _, field := range fields[1:] {
+				number, err := strconv.ParseUint(field, 10, 64)
+
+				if err != nil {
+					return nil, err
+				}
+
+				numbers = append(numbers, number)
+			}
+		}
+
+		switch {
+		case len(fields) == 3 && fields[0] == "hit":
+			coverage.hits[uint16(numbers[0])] = numbers[1]
+
+		case len(fields) == 4 && fields[0] == "branch":
+			coverage.branches[uint16(numbers[0])] = &[2]uint64{numbers[1], numbers[2]}
+
+		default:
+			if err := coverage.sourceMap.ParseLine(scanner.Text()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(coverage.sourceMap.Locations()) == 0 {
+		return nil, errors.New(path + ": no source map in the coverage, compile the program with --map")
+	}
+
+	return coverage, scanner.Err()
+}
+
+const (
+	CoverageNone = iota // no instruction on the line
+	CoverageMissed
+	CoveragePartial
+	CoverageCovered
+)
+
+type CoverageLine struct {
+	state          int
+	hits           uint64
+	taken, skipped uint64
+	conditional    bool
+}
+
+type CoverageFile struct {
+	path  string
+	lines map[uint64]*CoverageLine
+	text  map[uint64]string // the assembled text of the lines, shown when the file can't be read
+}
+
+/* the coverage of every source file, sorted by path */
+func (this *Coverage) Files() []*CoverageFile {
+	files := make(map[string]*CoverageFile)
+	var paths []string
+
+	for _, location := range this.sourceMap.Locations() {
+		/* declarations are never executed, they aren't counted as lines to cover */
+		if location.Data {
+			continue
+		}
+
+		file := files[location.Span.Stream]
+
+		if file == nil {
+			file = &CoverageFile{location.Span.Stream, make(map[uint64]*CoverageLine), make(map[uint64]string)}
+			files[file.path] = file
+			paths = append(paths, file.path)
+		}
+
+		line := file.lines[location.Span.Row]
+
+		if line == nil {
+			line = &CoverageLine{CoverageNone, 0, 0, 0, false}
+			file.lines[location.Span.Row] = line
+			file.text[location.Span.Row] = location.Text
+		}
+
+		line.hits = max(line.hits, this.hits[location.Address])
+
+		if branch := this.branches[location.Address]; branch != nil {
+			line.conditional = true
+			line.taken += branch[0]
+			line.skipped += branch[1]
+		}
+
+		switch {
+		case line.hits == 0:
+			line.state = CoverageMissed
+		case line.conditional && (line.taken == 0 || line.skipped == 0):
+			line.state = CoveragePartial
+		default:
+			line.state = CoverageCovered
+		}
+	}
+
+	sort.Strings(paths)
+	var sorted []*CoverageFile
+
+	for _, path := range paths {
+		sorted = append(sorted, files[path])
+	}
+
+	return sorted
+}
+
+/* covered lines over lines with instructions, and branches both taken and skipped over conditional lines */
+func (this *CoverageFile) Totals() (lines, covered, branches, complete int) {
+	for _, line := range this.lines {
+		lines++
+
+		if line.state != CoverageMissed {
+			covered++
+		}
+
+		if line.conditional {
+			branches++
+
+			if line.taken != 0 && line.skipped != 0 {
+				complete++
+			}
+		}
+	}
+
+	return
+}
+
+/* the lines of the source file, or the assembled text of its instructions when it can't be read */
+func (this *CoverageFile) Source() []string {
+	if content, err := os.ReadFile(this.path); err == nil {
+		return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	var last uint64
+
+	for row := range this.lines {
+		last = max(last, row)
+	}
+
+	source := make([]string, last)
+
+	for row, text := range this.text {
+		source[row-1] = text
+	}
+
+	return source
+}
+
+func Percent(part, whole int) float64 {
+	if whole == 0 {
+		return 100
+	}
+
+	return 100 * float64(part) / float64(whole)
+}
+
+func (this *CoverageLine) Annotation() string {
+	switch {
+	case this.state == CoverageNone:
+		return ""
+	case this.conditional:
+		return fmt.Sprintf("%d (taken %d, skipped %d)", this.hits, this.taken, this.skipped)
+	default:
+		return fmt.Sprint(this.hits)
+	}
+}
+
+/* a summary per file, followed by the sources with the count of every line, missed lines marked with ! and partial ones with ? */
+func (this *Coverage) WriteText(writer io.Writer) {
+	files := this.Files()
+
+	for _, file := range files {
+		lines, covered, branches, complete := file.Totals()
+		fmt.Fprintf(writer, "%s: %.1f%% of lines (%d/%d), %.1f%% of branches (%d/%d)\n", file.path, Percent(covered, lines), covered, lines, Percent(complete, branches), complete, branches)
+	}
+
+	for _, file := range files {
+		fmt.Fprintf(writer, "\n%s:\n", file.path)
+
+		for index, text := range file.Source() {
+			marker, annotation := " ", ""
+
+			if line := file.lines[uint64(index+1)]; line != nil {
+				annotation = line.Annotation()
+
+				switch line.state {
+				case CoverageMissed:
+					marker = "!"
+				case CoveragePartial:
+					marker = "?"
+				}
+			}
+
+			fmt.Fprintf(writer, "%s %5d %-32s | %s\n", marker, index+1, annotation, text)
+		}
+	}
+}
+
+var CoverageColors = map[int]string{CoverageNone: "", CoverageMissed: "#f4c7c3", CoveragePartial: "#fce8b2", CoverageCovered: "#b7e1cd"}
+
+func (this *Coverage) WriteHTML(writer io.Writer) error {
+	buffered := bufio.NewWriter(writer)
+	fmt.Fprintln(buffered, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>nfasm coverage</title><style>")
+	fmt.Fprintln(buffered, "body { font-family: sans-serif; } pre { margin: 0; } td { font-family: monospace; padding: 0 8px; white-space: pre; } .count { color: #666; text-align: right; }")
+	fmt.Fprintln(buffered, "</style></head><body>")
+
+	for _, file := range this.Files() {
+		lines, covered, branches, complete := file.Totals()
+		fmt.Fprintf(buffered, "<h2>%s</h2>\n<p>%.1f%% of lines (%d/%d), %.1f%% of branches (%d/%d)</p>\n<table cellspacing=\"0\">\n", html.EscapeString(file.path), Percent(covered, lines), covered, lines, Percent(complete, branches), complete, branches)
+
+		for index, text := range file.Source() {
+			color, annotation := "", ""
+
+			if line := file.lines[uint64(index+1)]; line != nil {
+				color, annotation = CoverageColors[line.state], line.Annotation()
+			}
+
+			fmt.Fprintf(buffered, "<tr style=\"background: %s\"><td class=\"count\">%d</td><td class=\"count\">%s</td><td>%s</td></tr>\n", color, index+1, html.EscapeString(annotation), html.EscapeString(text))
+		}
+
+		fmt.Fprintln(buffered, "</table>")
+	}
+
+	fmt.Fprintln(buffered, "</body></html>")
+	return buffered.Flush()
+}
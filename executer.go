@@ -1,16 +1,357 @@
 package main
 
-import "os"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
-func Execute(path string, arguments []string) {
-    cpu := NewCPU(false)
-    cpu.LoadProgramFromFile(path)
-    err := cpu.Run(arguments)
+	"nfasm/asm"
+	"nfasm/vm"
+)
+
+const WatchInterval = 250 * time.Millisecond
+
+const (
+    ExitCodeError = 1
+    ExitCodeUsage = 2
+    ExitCodeSyscallDenied = 121
+    ExitCodeOutputLimit = 122
+    ExitCodeTimeLimit = 123
+    ExitCodeStepLimit = 124
+)
+
+type ExecuteOptions struct {
+    debug, keyboard bool
+    trace, stdin, sandbox string
+    limits vm.Limits
+    syscalls string
+    environment []string
+    frames, frameFormat string
+    frameEvery uint64
+    console string
+    profile bool
+    profileOutput string
+    coverage string
+    saveOnExit, restore string
+    record, replay string
+}
+
+func NewExecuteOptions() ExecuteOptions {
+    return ExecuteOptions{false, false, "", "", "", vm.Limits{}, "", nil, "", "ppm", 0, vm.ConsoleModeNone, false, "", "", "", "", "", ""}
+}
+
+/* applies the options to a cpu holding its program, the returned function releases what they opened */
+func (this *ExecuteOptions) Configure(cpu *vm.CPU, sourceMap *asm.SourceMap) (func(), error) {
+    var opened []*os.File
+
+    release := func() {
+	for _, file := range opened {
+	    file.Close()
+	}
+    }
+
+    cpu.SetEnvironment(this.environment)
+    limits := this.limits
+    var err error
+
+    if limits.Syscalls, err = vm.ParseSyscalls(this.syscalls); err != nil {
+	return release, err
+    }
+
+    cpu.SetLimits(limits)
+
+    if err := cpu.SetSandbox(this.sandbox); err != nil {
+	return release, err
+    }
+
+    if err := cpu.GPU().SetOutput(this.frames, this.frameFormat, this.frameEvery); err != nil {
+	return release, err
+    }
+
+    if err := cpu.Console().SetOutput(os.Stdout, this.console); err != nil {
+	return release, err
+    }
+
+    if this.stdin != "" {
+	file, err := os.Open(this.stdin)
+
+	if err != nil {
+	    return release, err
+	}
+
+	opened = append(opened, file)
+	cpu.SetInput(file)
+    }
+
+    if this.record != "" && this.replay != "" {
+	return release, errors.New("--record and --replay can't be used together")
+    }
+
+    if this.record != "" {
+	file, err := os.Create(this.record)
+
+	if err != nil {
+	    return release, err
+	}
+
+	opened = append(opened, file)
+	cpu.SetRecording(vm.NewRecorder(file))
+    }
+
+    if this.replay != "" {
+	recording, err := vm.ReadRecording(this.replay)
+
+	if err != nil {
+	    return release, err
+	}
+
+	cpu.SetRecording(recording)
+    }
+
+    /* the keyboard takes stdin over, the read syscall sees its end, a replay gets its keys from the recording */
+    if this.keyboard {
+	input := cpu.Input()
+	cpu.SetInput(strings.NewReader(""))
+
+	if this.replay == "" {
+	    cpu.Keyboard().Connect(input)
+	}
+    }
+
+    if this.trace != "" {
+	var writer io.Writer = os.Stderr
+
+	if this.trace != "-" {
+	    file, err := os.Create(this.trace)
+
+	    if err != nil {
+		return release, err
+	    }
+
+	    opened = append(opened, file)
+	    writer = file
+	}
+
+	/* the tracer is flushed before the files are closed */
+	tracer := NewTracer(writer, sourceMap)
+	cpu.AddObserver(tracer)
+	close := release
+
+	release = func() {
+	    if err := tracer.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	    }
+
+	    close()
+	}
+    }
+
+    if this.profile || this.profileOutput != "" {
+	/* reported when released, once the program stopped and its source map is loaded */
+	profiler := NewProfiler(sourceMap)
+	cpu.AddObserver(profiler)
+	close := release
+
+	release = func() {
+	    if this.profile {
+		profiler.Report(os.Stderr, cpu)
+	    }
+
+	    if this.profileOutput != "" {
+		if err := profiler.WritePprofFile(this.profileOutput, cpu); err != nil {
+		    fmt.Fprintln(os.Stderr, err)
+		}
+	    }
+
+	    close()
+	}
+    }
+
+    if this.coverage != "" {
+	coverage := NewCoverage(sourceMap)
+	cpu.AddObserver(coverage)
+	close := release
+
+	release = func() {
+	    if err := coverage.WriteFile(this.coverage); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	    }
+
+	    close()
+	}
+    }
+
+    if this.saveOnExit != "" {
+	close := release
+
+	release = func() {
+	    if err := cpu.SaveSnapshot(this.saveOnExit); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	    }
+
+	    close()
+	}
+    }
+
+    return release, nil
+}
+
+/* reports how the run of a configured cpu went, returning the exit code of the whole process */
+func Report(cpu *vm.CPU, sourceMap *asm.SourceMap, err error) int {
+    if finish := cpu.FinishRecording(); err == nil {
+	err = finish
+    }
+
+    var fault *vm.Fault
+    var executionError *vm.ExecutionError
+
+    if sourceMap != nil && errors.As(err, &executionError) {
+	err = fmt.Errorf("%s: %w", sourceMap.Describe(executionError.IP), err)
+    }
+
+    if errors.As(err, &fault) {
+	fmt.Fprintln(os.Stderr, err)
+	return fault.ExitCode()
+    } else if errors.Is(err, vm.ErrStepLimit) {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeStepLimit
+    } else if errors.Is(err, vm.ErrTimeLimit) {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeTimeLimit
+    } else if errors.Is(err, vm.ErrOutputLimit) {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeOutputLimit
+    } else if errors.Is(err, vm.ErrSyscallDenied) {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeSyscallDenied
+    } else if err != nil {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeError
+    }
+
+    cpu.Debugger().Log("exit code: ", cpu.ExitCode())
+    return int(cpu.ExitCode())
+}
+
+func Execute(path string, arguments []string, options ExecuteOptions) int {
+    cpu := vm.NewCPU(options.debug)
+    var err error
+    var sourceMap *asm.SourceMap
+
+    if options.restore != "" {
+	err = cpu.RestoreSnapshot(options.restore)
+    } else {
+	err = cpu.LoadProgramFromFile(path)
+    }
+
+    /* a restored machine already holds its program, the path is optional and only names its source map */
+    if err == nil && path != "" {
+	sourceMap, err = LoadSourceMap(path)
+    }
+
+    if err != nil {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeError
+    }
+
+    return Launch(cpu, sourceMap, arguments, options)
+}
+
+/* the source map next to a program, nil when there is none */
+func LoadSourceMap(program string) (*asm.SourceMap, error) {
+    sourceMap, err := asm.ReadSourceMap(program + ".map")
+
+    if errors.Is(err, fs.ErrNotExist) {
+	return nil, nil
+    }
+
+    return sourceMap, err
+}
+
+/* configures a loaded cpu, then runs the program from its entry or resumes a restored snapshot */
+func Launch(cpu *vm.CPU, sourceMap *asm.SourceMap, arguments []string, options ExecuteOptions) int {
+    release, err := options.Configure(cpu, sourceMap)
+    defer release()
 
     if err != nil {
-	panic(err)
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeError
     }
 
-    cpu.debugger.Log("exit code: ", cpu.b)
-    os.Exit(int(cpu.b))
+    if options.restore != "" {
+	return Report(cpu, sourceMap, cpu.Resume(context.Background()))
+    }
+
+    return Report(cpu, sourceMap, cpu.Run(context.Background(), arguments))
+}
+
+/* assembles the source and executes it without writing anything to disk */
+func RunSource(path string, arguments []string, includes []string, options ExecuteOptions) int {
+    program, err := asm.AssembleFile(path, includes)
+
+    if err != nil {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeError
+    }
+
+    cpu := vm.NewCPU(options.debug)
+
+    if options.restore != "" {
+	err = cpu.RestoreSnapshot(options.restore)
+    } else {
+	err = cpu.LoadProgramFromMemory(program.Words())
+    }
+
+    if err != nil {
+	fmt.Fprintln(os.Stderr, err)
+	return ExitCodeError
+    }
+
+    return Launch(cpu, program.SourceMap(), arguments, options)
+}
+
+/* runs the source again every time it or one of the files it includes changes, never returns */
+func WatchSource(path string, arguments []string, includes []string, options ExecuteOptions) {
+    for {
+	code := RunSource(path, arguments, includes, options)
+	fmt.Fprintf(os.Stderr, "exit code %d, waiting for changes\n", code)
+
+	files := []string{path}
+
+	if tree, err := asm.ParseFile(path, includes, map[string]bool{}); err == nil {
+	    for _, ast := range tree {
+		if !slices.Contains(files, ast.Span().Stream) {
+		    files = append(files, ast.Span().Stream)
+		}
+	    }
+	}
+
+	WaitForChange(files)
+    }
+}
+
+func WaitForChange(files []string) {
+    modified := func() []time.Time {
+	times := make([]time.Time, len(files))
+
+	for index, file := range files {
+	    if info, err := os.Stat(file); err == nil {
+		times[index] = info.ModTime()
+	    }
+	}
+
+	return times
+    }
+
+    initial := modified()
+
+    for slices.Equal(initial, modified()) {
+	time.Sleep(WatchInterval)
+    }
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"nfasm/vm"
+)
+
+func TestReportLimitExitCodes(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: 1", vm.ErrSyscallDenied), ExitCodeSyscallDenied},
+		{fmt.Errorf("%w: 10 bytes", vm.ErrOutputLimit), ExitCodeOutputLimit},
+		{vm.ErrTimeLimit, ExitCodeTimeLimit},
+		{vm.ErrStepLimit, ExitCodeStepLimit},
+		{context.Canceled, ExitCodeError},
+	} {
+		if code := Report(vm.NewCPU(false), nil, test.err); code != test.code {
+			t.Errorf("%v: got exit code %d, want %d", test.err, code, test.code)
+		}
+	}
+}
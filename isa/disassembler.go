@@ -0,0 +1,108 @@
+package isa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+func UserStatesAsString(states uint16) string {
+	switch states & (UserStateConditionMask | UserStateNegated) {
+	case UserStateDefault:
+		return ""
+
+	case UserStateZero:
+		return "eq"
+
+	case UserStateZero | UserStateNegated:
+		return "ne"
+
+	case UserStateCarry:
+		return "gt"
+
+	case UserStateOverflow:
+		return "lt"
+
+	case UserStateCarry | UserStateZero:
+		return "ge"
+
+	case UserStateOverflow | UserStateZero:
+		return "le"
+
+	default:
+		return fmt.Sprintf("0x%04x", states&(UserStateConditionMask|UserStateNegated))
+	}
+}
+
+/* disassembles the instruction at address of program, returning its text and its size in words */
+func Disassemble(program []uint16, address uint16) (string, uint16, error) {
+	if int(address)+1 >= len(program) {
+		return "", 0, errors.New("failed to disassemble: address out of bounds")
+	}
+
+	opcode, states := program[address], program[address+1]
+	name, err := OpcodeAsString(opcode)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	operands, _ := OpcodeOperandsCount(opcode)
+
+	if int(address)+2+int(operands) > len(program) {
+		return "", 0, errors.New("failed to disassemble: truncated instruction")
+	}
+
+	text := name
+
+	for index := uint16(0); index < operands; index++ {
+		operand := program[address+2+index]
+		last := index == operands-1
+
+		if index == 0 {
+			text += " "
+		} else {
+			text += ", "
+		}
+
+		if last && states&UserStateImmediate != 0x0000 {
+			text += strconv.Itoa(int(operand))
+		} else if register, err := RegisterAsString(operand); err != nil {
+			return "", 0, err
+		} else {
+			text += register
+		}
+	}
+
+	if condition := UserStatesAsString(states); condition != "" {
+		text += ", " + condition
+	}
+
+	return text, 2 + operands, nil
+}
+
+/* prints the whole program, words that do not decode (data, mostly) are printed as dw */
+func DisassembleFile(writer io.Writer, path string) error {
+	program, err := ReadProgram(path)
+
+	if err != nil {
+		return err
+	}
+
+	for address := uint16(0); int(address) < len(program); {
+		text, size, err := Disassemble(program, address)
+
+		if err != nil {
+			text, size = "dw "+strconv.Itoa(int(program[address])), 1
+		}
+
+		if _, err := fmt.Fprintf(writer, "%04x: %s\n", address, text); err != nil {
+			return err
+		}
+
+		address += size
+	}
+
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package isa
 
 const (
     MemorySize        = 4096
@@ -9,4 +9,8 @@ const (
 
     SegmentTextSize = SegmentDataStart - SegmentTextStart
     SegmentDataSize = SegmentHeapStart - SegmentDataStart
+
+    // the interrupt vector table takes the beginning of the data segment, see interrupts.go
+    InterruptVectorStart = SegmentDataStart
+    InterruptVectorCount = 32
 )
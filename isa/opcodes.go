@@ -1,4 +1,4 @@
-package main
+package isa
 
 import "errors"
 
@@ -26,19 +26,25 @@ const (
     OpcodeInc = 20
     OpcodeDec = 21
     OpcodeCmp = 22
-    OpcodeCount = 23
+    OpcodeInt = 23
+    OpcodeIret = 24
+    OpcodeCli = 25
+    OpcodeSti = 26
+    OpcodeVld = 27
+    OpcodeVst = 28
+    OpcodeCount = 29
 )
 
 func OpcodeAsString(opcode uint16) (string, error) {
     if opcode >= OpcodeCount {
 	return "", errors.New("opcode out of bounds")
     } else {
-	return []string{"nop", "mov", "add", "sub", "mul", "div", "rem", "or", "xor", "and", "not", "la", "las", "str", "syscall", "jmp", "jmpl", "push", "pop", "ret", "inc", "dec", "cmp"}[opcode], nil
+	return []string{"nop", "mov", "add", "sub", "mul", "div", "rem", "or", "xor", "and", "not", "la", "las", "str", "syscall", "jmp", "jmpl", "push", "pop", "ret", "inc", "dec", "cmp", "int", "iret", "cli", "sti", "vld", "vst"}[opcode], nil
     }
 }
 
 func OpcodeAsInt(opcode string) (uint16, error) {
-    for index, value := range []string{"nop", "mov", "add", "sub", "mul", "div", "rem", "or", "xor", "and", "not", "la", "las", "str", "syscall", "jmp", "jmpl", "push", "pop", "ret", "inc", "dec", "cmp"} {
+    for index, value := range []string{"nop", "mov", "add", "sub", "mul", "div", "rem", "or", "xor", "and", "not", "la", "las", "str", "syscall", "jmp", "jmpl", "push", "pop", "ret", "inc", "dec", "cmp", "int", "iret", "cli", "sti", "vld", "vst"} {
 	if opcode == value  {
 	    return uint16(index), nil
 	}
@@ -46,3 +52,21 @@ func OpcodeAsInt(opcode string) (uint16, error) {
 
     return 0, errors.New("opcode out of bounds")
 }
+
+
+/* number of operand words following <opcode> <user states>, used to skip instructions whose user states do not match */
+func OpcodeOperandsCount(opcode uint16) (uint16, error) {
+    switch opcode {
+    case OpcodeNop, OpcodeSyscall, OpcodeRet, OpcodeIret, OpcodeCli, OpcodeSti:
+	return 0, nil
+
+    case OpcodeNot, OpcodeLas, OpcodeJmp, OpcodeJmpl, OpcodePush, OpcodePop, OpcodeInc, OpcodeDec, OpcodeInt:
+	return 1, nil
+
+    case OpcodeMov, OpcodeAdd, OpcodeSub, OpcodeMul, OpcodeDiv, OpcodeRem, OpcodeOr, OpcodeXor, OpcodeAnd, OpcodeLa, OpcodeStr, OpcodeCmp, OpcodeVld, OpcodeVst:
+	return 2, nil
+
+    default:
+	return 0, errors.New("opcode out of bounds")
+    }
+}
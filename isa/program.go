@@ -0,0 +1,23 @@
+package isa
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+/* reads a compiled program, little endian words */
+func ReadProgram(path string) ([]uint16, error) {
+    buffer, err := os.ReadFile(path)
+
+    if err != nil {
+	return nil, err
+    }
+
+    program := make([]uint16, len(buffer) / 2)
+
+    for index := range program {
+	program[index] = binary.LittleEndian.Uint16(buffer[index * 2:])
+    }
+
+    return program, nil
+}
@@ -1,4 +1,4 @@
-package main
+package isa
 
 import "errors"
 
@@ -30,7 +30,7 @@ const (
 /	ip (instruction pointer) (reserved, inaccessible) (for now)
 /	lr (link register) (reserved, accessible)
 /	dp (data pointer) (reserved, inaccessible)
-/	hp (heap pointer) (reserved, inaccessible) // too lazy to implement a heap system
+/	hp (heap pointer) (reserved, inaccessible) the heap break, see heap.go
 /	sp (stack pointer) (reserved, inaccessible) (for now)
 /	usr (user states register) (reserved, inaccessible) (for now)
 /	rsr (reserverd states register) (reserved, inaccessible)
@@ -39,7 +39,7 @@ const (
 /
 / Breaking down (listing bitwisely in the corresponding order): (for now)
 /	the usr (user states register) holds these flags: [overflow, carry, zero, immediate]
-/	the rsr (reserved states register) hold these flags: [running]
+/	the rsr (reserved states register) hold these flags: [interrupts enabled, running]
 /	the opar (opcode/operand addressing register) is a temporary register used to store opcodes/operands in the Fetch/Decode processes
 /	the usar (user states addressing register) is a reserved register used to store the states of the current running instruction (in Decode process)
 /
@@ -0,0 +1,20 @@
+package isa
+
+const (
+    // User
+    UserStateDefault = 0x0000
+    UserStateImmediate = 0x0001
+    UserStateZero = 0x0002
+    UserStateCarry = 0x0004
+    UserStateOverflow  = 0x0008
+    UserStateNegated = 0x0010		// only meaningful in usar, inverts the condition
+    UserStateCount = 0x0006
+
+    UserStateConditionMask = UserStateZero | UserStateCarry | UserStateOverflow
+
+    // Reserved
+    ReservedStateDefault = 0x0000
+    ReservedStateRunning = 0x0001
+    ReservedStateInterrupts = 0x0002	// interrupt enable flag, set by sti and cleared by cli
+    ReservedStateCount = 0x0003
+)
@@ -1,33 +1,283 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
 )
 
-const MinimumRequiredArgsCount int = 3
+const Version = "0.2.0"
 
 func Usage(executableName string) {
-    fmt.Printf("usage: %s [com|exe]\n", executableName)
-    os.Exit(1)
+    fmt.Fprintf(os.Stderr, `usage: %s <command> [options]
+
+commands:
+    com [-o output] [-I dir] [--listing] [--map] [--format raw|hex] <source>
+    exe [options] <program> [--] [arguments]
+    run [--watch] [options] <source> [--] [arguments]
+    debug [--history count] [options] <program> [--] [arguments]
+    dis <program>
+    test [-v] [--parallel count] [--max-steps count] [-I directory] <directory or file>...
+    cover [--html output] <coverage>
+    trace [--from address] [--to address] [--label name] [--register name] [--summary] <trace.jsonl>
+    repl
+    map
+    version
+
+run "%s <command> -h" for the options of a command
+`, executableName, executableName)
+    os.Exit(ExitCodeUsage)
+}
+
+/* the program path followed by its arguments, a "--" right after the path is dropped */
+func ProgramArguments(arguments []string) []string {
+    if len(arguments) > 1 && arguments[1] == "--" {
+	return append([]string{arguments[0]}, arguments[2:]...)
+    }
+
+    return arguments
+}
+
+type StringsFlag []string
+
+func (this *StringsFlag) String() string {
+    return strings.Join(*this, ",")
+}
+
+func (this *StringsFlag) Set(value string) error {
+    *this = append(*this, value)
+    return nil
+}
+
+func NewFlagSet(name, arguments string) *flag.FlagSet {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+
+    flags.Usage = func() {
+	fmt.Fprintf(os.Stderr, "usage: %s %s %s\n", os.Args[0], name, arguments)
+	flags.PrintDefaults()
+    }
+
+    return flags
+}
+
+func ExecuteFlags(flags *flag.FlagSet, options *ExecuteOptions) {
+    flags.BoolVar(&options.debug, "debug", false, "log every fetch and the registers when the program stops")
+    flags.StringVar(&options.trace, "trace", "", "write a json lines trace of every executed instruction to a file, - for stderr")
+    flags.Uint64Var(&options.limits.MaxSteps, "max-steps", 0, "stop after this many instructions, 0 for no limit")
+    flags.DurationVar(&options.limits.Timeout, "timeout", 0, "stop after this much time (1s, 500ms), 0 for no limit")
+    flags.Uint64Var(&options.limits.MaxOutput, "max-output", 0, "stop when the program writes more than this many bytes, 0 for no limit")
+    flags.StringVar(&options.syscalls, "syscalls", "", "comma separated syscalls allowed (exit,write or numbers), all of them by default")
+    flags.StringVar(&options.stdin, "stdin", "", "file to read stdin from instead of the terminal")
+    flags.BoolVar(&options.keyboard, "keyboard", false, "feed stdin to the keyboard device instead of the read syscall")
+    flags.StringVar(&options.sandbox, "sandbox", "", "directory the file syscalls are confined to")
+    flags.Var((*StringsFlag)(&options.environment), "env", "KEY=VALUE added to the program environment, repeatable")
+    flags.StringVar(&options.frames, "frames", "", "directory the gpu frames are written to")
+    flags.StringVar(&options.frameFormat, "frame-format", "ppm", "format of the gpu frames, ppm or png")
+    flags.Uint64Var(&options.frameEvery, "frame-every", 0, "also write a frame every this many instructions")
+    flags.StringVar(&options.console, "console", vm.ConsoleModeNone, "render the console device, ansi or text")
+    flags.BoolVar(&options.profile, "profile", false, "print the instructions and labels the program spent its cycles in")
+    flags.StringVar(&options.profileOutput, "profile-output", "", "write the profile for go tool pprof to a file")
+    flags.StringVar(&options.record, "record", "", "write the inputs of the program (syscall results, device reads, interrupts) to a file")
+    flags.StringVar(&options.replay, "replay", "", "feed the inputs written by --record back to the program, failing when it diverges")
+    flags.StringVar(&options.saveOnExit, "save-on-exit", "", "write a snapshot of the machine to a file once the program stops")
+    flags.StringVar(&options.restore, "restore", "", "resume the machine saved in a snapshot file, the program is then optional")
+    flags.StringVar(&options.coverage, "coverage", "", "write the instructions and branches the program reached to a file, read by cover")
 }
 
 func main() {
-    if len(os.Args) < MinimumRequiredArgsCount {
+    if len(os.Args) < 2 {
 	Usage(os.Args[0])
     }
 
     switch os.Args[1] {
     case "com":
-	Compile(os.Args[2])
-	break
+	options := asm.NewCompileOptions()
+	flags := NewFlagSet("com", "[options] <source>")
+	flags.StringVar(&options.Output, "o", "", "output path, the source path without its extension by default")
+	flags.Var((*StringsFlag)(&options.Includes), "I", "directory searched for included files, repeatable")
+	flags.BoolVar(&options.Listing, "listing", false, "print the generated words next to their source")
+	flags.StringVar(&options.Format, "format", "raw", "output format, raw (little endian words) or hex (one word per line)")
+	flags.BoolVar(&options.SourceMap, "map", false, "write a source map next to the output, used by exe to locate errors")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() != 1 {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	if err := asm.Compile(flags.Arg(0), options); err != nil {
+	    fmt.Fprintln(os.Stderr, err)
+	    os.Exit(ExitCodeError)
+	}
 
     case "exe":
-	Execute(os.Args[2], os.Args[2:])
-	break
+	options := NewExecuteOptions()
+	flags := NewFlagSet("exe", "[options] <program> [--] [arguments]")
+	ExecuteFlags(flags, &options)
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() < 1 && options.restore == "" {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	os.Exit(Execute(flags.Arg(0), ProgramArguments(flags.Args()), options))
+
+    case "run":
+	options := NewExecuteOptions()
+	var includes []string
+	flags := NewFlagSet("run", "[options] <source> [--] [arguments]")
+	ExecuteFlags(flags, &options)
+	flags.Var((*StringsFlag)(&includes), "I", "directory searched for included files, repeatable")
+	watch := flags.Bool("watch", false, "run again whenever the source or an included file changes")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() < 1 {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	if *watch {
+	    WatchSource(flags.Arg(0), ProgramArguments(flags.Args()), includes, options)
+	}
+
+	os.Exit(RunSource(flags.Arg(0), ProgramArguments(flags.Args()), includes, options))
+
+    case "dis":
+	flags := NewFlagSet("dis", "<program>")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() != 1 {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	if err := isa.DisassembleFile(os.Stdout, flags.Arg(0)); err != nil {
+	    fmt.Fprintln(os.Stderr, err)
+	    os.Exit(ExitCodeError)
+	}
+
+    case "trace":
+	filter := TraceFilter{0, 0xffff, "", "", nil}
+	flags := NewFlagSet("trace", "[options] <trace.jsonl>")
+	from := flags.Uint("from", 0, "only show instructions at or after this address")
+	to := flags.Uint("to", 0xffff, "only show instructions at or before this address")
+	flags.StringVar(&filter.label, "label", "", "only show instructions under this label")
+	flags.StringVar(&filter.register, "register", "", "only show instructions changing this register")
+	sourceMap := flags.String("map", "", "source map to resolve labels with, instead of the labels recorded in the trace")
+	summary := flags.Bool("summary", false, "print counts instead of the instructions")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() != 1 {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	filter.from, filter.to = uint16(*from), uint16(*to)
+
+	if *sourceMap != "" {
+	    loaded, err := asm.ReadSourceMap(*sourceMap)
+
+	    if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitCodeError)
+	    }
+
+	    filter.sourceMap = loaded
+	}
+
+	if err := ReadTrace(os.Stdout, flags.Arg(0), filter, *summary); err != nil {
+	    fmt.Fprintln(os.Stderr, err)
+	    os.Exit(ExitCodeError)
+	}
+
+    case "cover":
+	flags := NewFlagSet("cover", "[--html output] <coverage>")
+	output := flags.String("html", "", "write an html report to this file instead of the text report")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() != 1 {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	coverage, err := ReadCoverage(flags.Arg(0))
+
+	if err != nil {
+	    fmt.Fprintln(os.Stderr, err)
+	    os.Exit(ExitCodeError)
+	}
+
+	if *output == "" {
+	    coverage.WriteText(os.Stdout)
+	    break
+	}
+
+	file, err := os.Create(*output)
+
+	if err == nil {
+	    err = coverage.WriteHTML(file)
+	    file.Close()
+	}
+
+	if err != nil {
+	    fmt.Fprintln(os.Stderr, err)
+	    os.Exit(ExitCodeError)
+	}
+
+    case "test":
+	options := TestOptions{nil, runtime.GOMAXPROCS(0), TestStepLimit, false}
+	flags := NewFlagSet("test", "[options] <directory or file>...")
+	flags.Var((*StringsFlag)(&options.includes), "I", "directory searched for included files, repeatable")
+	flags.IntVar(&options.parallel, "parallel", options.parallel, "number of tests run at the same time")
+	flags.Uint64Var(&options.maxSteps, "max-steps", options.maxSteps, "fail a test after this many instructions, 0 for no limit")
+	flags.BoolVar(&options.verbose, "v", false, "also list the tests that passed")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() < 1 {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	passed, err := RunTests(os.Stdout, flags.Args(), options)
+
+	if err != nil {
+	    fmt.Fprintln(os.Stderr, err)
+	    os.Exit(ExitCodeError)
+	} else if !passed {
+	    os.Exit(ExitCodeError)
+	}
+
+    case "debug":
+	options := NewExecuteOptions()
+	flags := NewFlagSet("debug", "[options] <program> [--] [arguments]")
+	ExecuteFlags(flags, &options)
+	history := flags.Int("history", vm.DefaultHistorySize, "number of instructions recorded to step back through, 0 to record nothing")
+	flags.Parse(os.Args[2:])
+
+	if flags.NArg() < 1 && options.restore == "" {
+	    flags.Usage()
+	    os.Exit(ExitCodeUsage)
+	}
+
+	os.Exit(Debug(flags.Arg(0), ProgramArguments(flags.Args()), options, *history))
+
+    case "repl":
+	NewRepl(os.Stdout).Loop(os.Stdin)
+
+    case "map":
+	fmt.Print(vm.NewCPU(false).MemoryMap())
+
+    case "version", "--version", "-v":
+	fmt.Println("nfasm", Version)
 
     default:
 	Usage(os.Args[0])
-	break
     }
 }
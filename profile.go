@@ -0,0 +1,381 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
+)
+
+/*
+/
+/ Profiles:
+/	"exe --profile" counts how often every address ran and estimates the cycles it took with OpcodeCycles, instructions
+/	skipped by their user states cost SkippedCycles, the report printed at exit sums them per label (the enclosing label
+/	from the source map), per instruction kind and per address
+/
+/	"exe --profile-output file.pb.gz" also writes the profile in the format of "go tool pprof", samples are stacks of
+/	the addresses jmpl was executed from, so flame graphs show which routine called which
+/
+*/
+
+var OpcodeCycles = [isa.OpcodeCount]uint64{
+	isa.OpcodeNop: 1, isa.OpcodeMov: 1, isa.OpcodeAdd: 1, isa.OpcodeSub: 1, isa.OpcodeMul: 3, isa.OpcodeDiv: 8, isa.OpcodeRem: 8,
+	isa.OpcodeOr: 1, isa.OpcodeXor: 1, isa.OpcodeAnd: 1, isa.OpcodeNot: 1, isa.OpcodeLa: 2, isa.OpcodeLas: 2, isa.OpcodeStr: 2,
+	isa.OpcodeSyscall: 10, isa.OpcodeJmp: 2, isa.OpcodeJmpl: 3, isa.OpcodePush: 2, isa.OpcodePop: 2, isa.OpcodeRet: 2,
+	isa.OpcodeInc: 1, isa.OpcodeDec: 1, isa.OpcodeCmp: 1, isa.OpcodeInt: 5, isa.OpcodeIret: 4, isa.OpcodeCli: 1, isa.OpcodeSti: 1,
+	isa.OpcodeVld: 2, isa.OpcodeVst: 2,
+}
+
+const (
+	SkippedCycles          = 1
+	ProfileReportAddresses = 20
+)
+
+type ProfileCounter struct {
+	count, skipped, cycles uint64
+}
+
+func (this *ProfileCounter) Add(executed bool, cycles uint64) {
+	this.count++
+	this.cycles += cycles
+
+	if !executed {
+		this.skipped++
+	}
+}
+
+type ProfileSample struct {
+	stack         []uint16 // the sampled address first, then the call sites
+	count, cycles uint64
+}
+
+type Profiler struct {
+	addresses map[uint16]*ProfileCounter
+	opcodes   [isa.OpcodeCount]ProfileCounter
+	samples   map[string]*ProfileSample
+	calls     []uint16
+	total     ProfileCounter
+	sourceMap *asm.SourceMap
+}
+
+/* sourceMap attributes the addresses to labels and lines, it may be nil */
+func NewProfiler(sourceMap *asm.SourceMap) *Profiler {
+	return &Profiler{make(map[uint16]*ProfileCounter), [isa.OpcodeCount]ProfileCounter{}, make(map[string]*ProfileSample), nil, ProfileCounter{}, sourceMap}
+}
+
+func (this *Profiler) Observe(cpu *vm.CPU, record *vm.StepRecord) {
+	/* the cycle stopped before its instruction was decoded */
+	if record.Opcode >= isa.OpcodeCount || !record.Executed && record.Err != nil {
+		return
+	}
+
+	cycles := uint64(SkippedCycles)
+
+	if record.Executed {
+		cycles = OpcodeCycles[record.Opcode]
+	}
+
+	counter, found := this.addresses[record.IP]
+
+	if !found {
+		counter = &ProfileCounter{}
+		this.addresses[record.IP] = counter
+	}
+
+	counter.Add(record.Executed, cycles)
+	this.opcodes[record.Opcode].Add(record.Executed, cycles)
+	this.total.Add(record.Executed, cycles)
+
+	stack := append([]uint16{record.IP}, this.calls...)
+	key := fmt.Sprint(stack)
+	sample, found := this.samples[key]
+
+	if !found {
+		sample = &ProfileSample{stack, 0, 0}
+		this.samples[key] = sample
+	}
+
+	sample.count++
+	sample.cycles += cycles
+
+	if !record.Executed || record.Err != nil {
+		return
+	}
+
+	switch record.Opcode {
+	case isa.OpcodeJmpl:
+		this.calls = append([]uint16{record.IP}, this.calls...)
+
+	case isa.OpcodeRet:
+		if len(this.calls) != 0 {
+			this.calls = this.calls[1:]
+		}
+	}
+}
+
+func ProfileLabel(sourceMap *asm.SourceMap, address uint16) string {
+	if sourceMap != nil {
+		if label := sourceMap.EnclosingLabel(address); label != nil {
+			return label.Name
+		}
+	}
+
+	return "?"
+}
+
+func WriteProfileCounters[K comparable](writer io.Writer, title string, counters map[K]*ProfileCounter, name func(K) string, limit int, total uint64) {
+	keys := make([]K, 0, len(counters))
+
+	for key := range counters {
+		keys = append(keys, key)
+	}
+
+	sort.SliceStable(keys, func(left, right int) bool {
+		if counters[keys[left]].cycles != counters[keys[right]].cycles {
+			return counters[keys[left]].cycles > counters[keys[right]].cycles
+		}
+
+		return name(keys[left]) < name(keys[right])
+	})
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	fmt.Fprintf(writer, "%s:\n    %10s %7s %10s %10s\n", title, "cycles", "%", "count", "skipped")
+
+	for _, key := range keys {
+		counter := counters[key]
+		fmt.Fprintf(writer, "    %10d %6.2f%% %10d %10d  %s\n", counter.cycles, 100*float64(counter.cycles)/float64(max(total, 1)), counter.count, counter.skipped, name(key))
+	}
+}
+
+func (this *Profiler) Report(writer io.Writer, cpu *vm.CPU) {
+	labels := make(map[string]*ProfileCounter)
+	opcodes := make(map[uint16]*ProfileCounter)
+
+	for address, counter := range this.addresses {
+		label := ProfileLabel(this.sourceMap, address)
+
+		if labels[label] == nil {
+			labels[label] = &ProfileCounter{}
+		}
+
+		labels[label].count += counter.count
+		labels[label].skipped += counter.skipped
+		labels[label].cycles += counter.cycles
+	}
+
+	for opcode := range this.opcodes {
+		if this.opcodes[opcode].count != 0 {
+			opcodes[uint16(opcode)] = &this.opcodes[opcode]
+		}
+	}
+
+	fmt.Fprintf(writer, "profile: %d instructions (%d skipped), %d estimated cycles\n", this.total.count, this.total.skipped, this.total.cycles)
+
+	WriteProfileCounters(writer, "labels", labels, func(label string) string { return label }, 0, this.total.cycles)
+
+	WriteProfileCounters(writer, "instructions", opcodes, func(opcode uint16) string {
+		name, _ := isa.OpcodeAsString(opcode)
+		return name
+	}, 0, this.total.cycles)
+
+	WriteProfileCounters(writer, "addresses", this.addresses, func(address uint16) string {
+		description := fmt.Sprintf("%04x %-24s", address, cpu.DisassembleAt(address))
+
+		if this.sourceMap != nil {
+			description += " " + this.sourceMap.Describe(address)
+		}
+
+		return description
+	}, ProfileReportAddresses, this.total.cycles)
+}
+
+/*
+/
+/ profile.proto is small enough to be encoded by hand, only the fields pprof needs are written:
+/	Profile: sample_type 1, sample 2, location 4, function 5, string_table 6, period_type 11, period 12
+/	ValueType: type 1, unit 2 (string table indices)
+/	Sample: location_id 1, value 2 (packed)
+/	Location: id 1, address 3, line 4
+/	Line: function_id 1, line 2
+/	Function: id 1, name 2, system_name 3, filename 4
+/
+*/
+
+type ProtoBuffer []byte
+
+func (this *ProtoBuffer) Varint(value uint64) {
+	for value >= 0x80 {
+		*this = append(*this, byte(value)|0x80)
+		value >>= 7
+	}
+
+	*this = append(*this, byte(value))
+}
+
+func (this *ProtoBuffer) Uint(field, value uint64) {
+	this.Varint(field << 3)
+	this.Varint(value)
+}
+
+func (this *ProtoBuffer) Bytes(field uint64, value []byte) {
+	this.Varint(field<<3 | 2)
+	this.Varint(uint64(len(value)))
+	*this = append(*this, value...)
+}
+
+func (this *ProtoBuffer) Packed(field uint64, values []uint64) {
+	var packed ProtoBuffer
+
+	for _, value := range values {
+		packed.Varint(value)
+	}
+
+	this.Bytes(field, packed)
+}
+
+type ProfileStrings struct {
+	table   []string
+	indices map[string]uint64
+}
+
+func (this *ProfileStrings) Index(value string) uint64 {
+	if index, found := this.indices[value]; found {
+		return index
+	}
+
+	this.indices[value] = uint64(len(this.table))
+	this.table = append(this.table, value)
+	return this.indices[value]
+}
+
+func (this *Profiler) WritePprof(writer io.Writer, cpu *vm.CPU) error {
+	var profile ProtoBuffer
+	strings := ProfileStrings{nil, make(map[string]uint64)}
+	strings.Index("")
+
+	valueType := func(kind, unit string) []byte {
+		var buffer ProtoBuffer
+		buffer.Uint(1, strings.Index(kind))
+		buffer.Uint(2, strings.Index(unit))
+		return buffer
+	}
+
+	profile.Bytes(1, valueType("instructions", "count"))
+	profile.Bytes(1, valueType("cycles", "count"))
+
+	keys := make([]string, 0, len(this.samples))
+
+	for key := range this.samples {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	/* location and function ids are addresses and labels numbered from 1 */
+	locations := make(map[uint16]uint64)
+	functions := make(map[string]uint64)
+	var addresses []uint16
+	var names []string
+
+	for _, key := range keys {
+		sample := this.samples[key]
+		var ids []uint64
+
+		for _, address := range sample.stack {
+			if locations[address] == 0 {
+				addresses = append(addresses, address)
+				locations[address] = uint64(len(addresses))
+			}
+
+			ids = append(ids, locations[address])
+		}
+
+		var buffer ProtoBuffer
+		buffer.Packed(1, ids)
+		buffer.Packed(2, []uint64{sample.count, sample.cycles})
+		profile.Bytes(2, buffer)
+	}
+
+	for _, address := range addresses {
+		label := ProfileLabel(this.sourceMap, address)
+		var row uint64
+
+		if this.sourceMap != nil {
+			if location := this.sourceMap.Lookup(address); location != nil {
+				row = location.Span.Row
+			}
+		}
+
+		if functions[label] == 0 {
+			names = append(names, label)
+			functions[label] = uint64(len(names))
+		}
+
+		var line, location ProtoBuffer
+		line.Uint(1, functions[label])
+		line.Uint(2, row)
+		location.Uint(1, locations[address])
+		location.Uint(3, uint64(address))
+		location.Bytes(4, line)
+		profile.Bytes(4, location)
+	}
+
+	for _, name := range names {
+		var function ProtoBuffer
+		var file string
+
+		if this.sourceMap != nil {
+			if label := this.sourceMap.Label(name); label != nil {
+				if location := this.sourceMap.Lookup(label.Address); location != nil {
+					file = location.Span.Stream
+				}
+			}
+		}
+
+		function.Uint(1, functions[name])
+		function.Uint(2, strings.Index(name))
+		function.Uint(3, strings.Index(name))
+		function.Uint(4, strings.Index(file))
+		profile.Bytes(5, function)
+	}
+
+	profile.Bytes(11, valueType("instructions", "count"))
+	profile.Uint(12, 1)
+
+	/* the string table goes last, every index has been handed out by now */
+	for _, value := range strings.table {
+		profile.Bytes(6, []byte(value))
+	}
+
+	compressed := gzip.NewWriter(writer)
+
+	if _, err := compressed.Write(profile); err != nil {
+		return err
+	}
+
+	return compressed.Close()
+}
+
+func (this *Profiler) WritePprofFile(path string, cpu *vm.CPU) error {
+	file, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := this.WritePprof(file, cpu); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
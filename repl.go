@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
+)
+
+/* a line looping back on itself is stopped after this many instructions */
+const ReplStepLimit = 100000
+
+/*
+/
+/ Repl:
+/	every line is assembled after the code of the previous ones, at the end of the text segment,
+/	and executed on the same cpu until ip reaches the end of the text again, then the registers it changed are shown
+/	labels stay defined for the whole session, they can only be referenced once defined
+/
+/	commands:
+/		:regs                 show every register
+/		:mem <address> [n]    show n words of memory from address (8 by default)
+/		:labels               show the labels defined so far
+/		:load <path>          assemble and execute a source file like a line
+/		:reset                start over with a fresh cpu
+/		:quit
+/
+*/
+
+type Repl struct {
+	cpu    *vm.CPU
+	labels []asm.Label
+	writer io.Writer
+}
+
+func NewRepl(writer io.Writer) *Repl {
+	repl := &Repl{nil, nil, writer}
+	repl.Reset()
+	return repl
+}
+
+func (this *Repl) Reset() {
+	this.cpu = vm.NewCPU(false)
+	this.cpu.SetOutput(this.writer, this.writer)
+	this.cpu.SetRunning(true)
+	this.labels = nil
+}
+
+func (this *Repl) Loop(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	fmt.Fprint(this.writer, "> ")
+
+	for scanner.Scan() {
+		if quit := this.Line(scanner.Text()); quit {
+			return
+		}
+
+		fmt.Fprint(this.writer, "> ")
+	}
+
+	fmt.Fprintln(this.writer)
+}
+
+/* handles a line of input, returning whether the session is over */
+func (this *Repl) Line(line string) bool {
+	line = strings.TrimSpace(line)
+
+	if line == "" {
+		return false
+	} else if strings.HasPrefix(line, ":") {
+		return this.Command(strings.Fields(line))
+	}
+
+	lexer := asm.NewLexer("<repl>", line)
+	parser, err := asm.NewParser(&lexer)
+
+	if err == nil {
+		var tree []asm.Ast
+
+		if tree, err = parser.Parse(); err == nil {
+			err = this.Assemble(tree)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(this.writer, err)
+	}
+
+	return false
+}
+
+func (this *Repl) Command(fields []string) bool {
+	switch fields[0] {
+	case ":quit", ":q":
+		return true
+
+	case ":regs":
+		for index, value := range this.cpu.RegisterValues() {
+			name, _ := isa.RegisterAsString(uint16(index))
+			fmt.Fprintf(this.writer, "%-5s %d\n", name, value)
+		}
+
+	case ":mem":
+		if err := DumpMemory(this.writer, this.cpu, fields[1:]); err != nil {
+			fmt.Fprintln(this.writer, err)
+		}
+
+	case ":labels":
+		for _, label := range this.labels {
+			fmt.Fprintf(this.writer, "%-16s %d\n", label.Name, label.Address)
+		}
+
+	case ":load":
+		if len(fields) != 2 {
+			fmt.Fprintln(this.writer, "usage: :load <path>")
+			break
+		}
+
+		tree, err := asm.ParseFile(fields[1], nil, map[string]bool{})
+
+		if err == nil {
+			err = this.Assemble(tree)
+		}
+
+		if err != nil {
+			fmt.Fprintln(this.writer, err)
+		}
+
+	case ":reset":
+		this.Reset()
+
+	default:
+		fmt.Fprintln(this.writer, "commands: :regs, :mem <address> [n], :labels, :load <path>, :reset, :quit")
+	}
+
+	return false
+}
+
+/* shows n words from address, the arguments of :mem */
+func DumpMemory(writer io.Writer, cpu *vm.CPU, arguments []string) error {
+	if len(arguments) == 0 || len(arguments) > 2 {
+		return errors.New("expected an address and an optional count")
+	}
+
+	address, err := strconv.ParseUint(arguments[0], 0, 16)
+
+	if err != nil {
+		return err
+	}
+
+	count := uint64(8)
+
+	if len(arguments) == 2 {
+		if count, err = strconv.ParseUint(arguments[1], 0, 16); err != nil {
+			return err
+		}
+	}
+
+	for index := uint64(0); index < count; index++ {
+		if index%8 == 0 {
+			fmt.Fprintf(writer, "%04x:", address+index)
+		}
+
+		value, err := cpu.ReadMemory(uint16(address + index))
+
+		if err != nil {
+			fmt.Fprintln(writer)
+			return err
+		}
+
+		fmt.Fprintf(writer, " %04x", value)
+
+		if index%8 == 7 || index == count-1 {
+			fmt.Fprintln(writer)
+		}
+	}
+
+	return nil
+}
+
+/* generates the tree after the current text, then executes it */
+func (this *Repl) Assemble(tree []asm.Ast) error {
+	labels := slices.Clone(this.labels)
+	program, err := asm.GenerateAt(tree, this.cpu.ProgramSize(), &labels)
+
+	if err != nil {
+		return err
+	}
+
+	start, err := this.cpu.AppendProgram(program)
+
+	if err != nil {
+		return err
+	}
+
+	this.labels = labels
+	this.Execute(start)
+	return nil
+}
+
+func (this *Repl) Execute(start uint16) {
+	before := this.cpu.RegisterValues()
+	end := this.cpu.ProgramSize()
+	this.cpu.SetRegister(isa.RegisterEncodingIP, start)
+
+	for steps := 0; this.cpu.Register(isa.RegisterEncodingIP) != end; steps++ {
+		var err error
+
+		if steps == ReplStepLimit {
+			err = vm.ErrStepLimit
+		} else {
+			err = this.cpu.Cycle()
+		}
+
+		if err != nil {
+			this.cpu.FlushOutput()
+			fmt.Fprintln(this.writer, err)
+			this.cpu.SetRegister(isa.RegisterEncodingIP, end)
+			break
+		}
+
+		if !this.cpu.Running() {
+			this.cpu.FlushOutput()
+			fmt.Fprintln(this.writer, "program exited with code", this.cpu.ExitCode())
+			this.cpu.SetRunning(true)
+			this.cpu.SetRegister(isa.RegisterEncodingIP, end)
+			break
+		}
+	}
+
+	this.cpu.FlushOutput()
+	this.ShowChanges(before, this.cpu.RegisterValues())
+}
+
+func (this *Repl) ShowChanges(before, after []uint16) {
+	for index := range after {
+		if before[index] == after[index] || index == isa.RegisterEncodingIP || index == isa.RegisterEncodingOPAR || index == isa.RegisterEncodingUSAR {
+			continue
+		}
+
+		name, _ := isa.RegisterAsString(uint16(index))
+
+		if index == isa.RegisterEncodingUSR {
+			fmt.Fprintf(this.writer, "%-5s %s -> %s\n", name, UserFlagsAsString(before[index]), UserFlagsAsString(after[index]))
+		} else {
+			fmt.Fprintf(this.writer, "%-5s %d -> %d\n", name, before[index], after[index])
+		}
+	}
+}
+
+func UserFlagsAsString(states uint16) string {
+	var flags []string
+
+	for _, flag := range []struct {
+		state uint16
+		name  string
+	}{{isa.UserStateZero, "zero"}, {isa.UserStateCarry, "carry"}, {isa.UserStateOverflow, "overflow"}} {
+		if states&flag.state != 0x0000 {
+			flags = append(flags, flag.name)
+		}
+	}
+
+	return "[" + strings.Join(flags, ", ") + "]"
+}
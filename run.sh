@@ -1,2 +1 @@
-./nfasm com tests/main.s
-./nfasm exe tests/main $@
+./nfasm run tests/main.s -- $@
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
+)
+
+/*
+/
+/ Debug sessions:
+/	"nfasm debug" loads a program and waits for commands before every instruction, with a history (see history.go)
+/	it can also go backwards, "--history 0" turns the recording off
+/
+/	commands:
+/		step [n], s             execute n instructions (1 by default)
+/		continue, c             execute until a breakpoint, the end of the program or an error
+/		back [n]                undo n instructions (1 by default)
+/		reverse-continue, rc    undo instructions until a breakpoint or the start of the history
+/		break <location>, b     stop before the instruction at an address or label
+/		delete <location>       remove a breakpoint
+/		last <register|address> show the last recorded instruction that wrote to a register or memory address
+/		where                   show the next instruction
+/		regs                    show every register
+/		mem <address> [n]       show n words of memory from address (8 by default)
+/		quit, q
+/
+*/
+
+type DebugSession struct {
+	cpu         *vm.CPU
+	sourceMap   *asm.SourceMap
+	history     *vm.History
+	breakpoints []uint16
+	writer      io.Writer
+}
+
+func NewDebugSession(cpu *vm.CPU, sourceMap *asm.SourceMap, historySize int, writer io.Writer) *DebugSession {
+	session := &DebugSession{cpu, sourceMap, nil, nil, writer}
+
+	if historySize > 0 {
+		session.history = vm.NewHistory(historySize)
+		cpu.AddObserver(session.history)
+	}
+
+	return session
+}
+
+func (this *DebugSession) Loop(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	this.Where()
+	fmt.Fprint(this.writer, "(nfasm) ")
+
+	for scanner.Scan() {
+		if quit := this.Command(strings.Fields(scanner.Text())); quit {
+			return
+		}
+
+		fmt.Fprint(this.writer, "(nfasm) ")
+	}
+
+	fmt.Fprintln(this.writer)
+}
+
+/* handles a line of input, returning whether the session is over */
+func (this *DebugSession) Command(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+
+	var err error
+
+	switch fields[0] {
+	case "quit", "q":
+		return true
+
+	case "step", "s":
+		var count uint64
+
+		if count, err = this.Count(fields[1:]); err == nil {
+			err = this.Forward(count, false)
+		}
+
+	case "continue", "c":
+		err = this.Forward(0, true)
+
+	case "back":
+		var count uint64
+
+		if count, err = this.Count(fields[1:]); err == nil {
+			err = this.Backward(count, false)
+		}
+
+	case "reverse-continue", "rc":
+		err = this.Backward(0, true)
+
+	case "break", "b", "delete":
+		var address uint16
+
+		if len(fields) != 2 {
+			err = errors.New("usage: " + fields[0] + " <address or label>")
+		} else if address, err = this.Location(fields[1]); err == nil && fields[0] == "delete" {
+			this.breakpoints = slices.DeleteFunc(this.breakpoints, func(breakpoint uint16) bool { return breakpoint == address })
+		} else if err == nil && !slices.Contains(this.breakpoints, address) {
+			this.breakpoints = append(this.breakpoints, address)
+		}
+
+	case "last":
+		if len(fields) != 2 {
+			err = errors.New("usage: last <register or address>")
+		} else {
+			err = this.LastWrite(fields[1])
+		}
+
+	case "where":
+		this.Where()
+
+	case "regs":
+		for index, value := range this.cpu.RegisterValues() {
+			name, _ := isa.RegisterAsString(uint16(index))
+			fmt.Fprintf(this.writer, "%-5s %d\n", name, value)
+		}
+
+	case "mem":
+		err = DumpMemory(this.writer, this.cpu, fields[1:])
+
+	default:
+		err = errors.New("commands: step [n], continue, back [n], reverse-continue, break <location>, delete <location>, last <register|address>, where, regs, mem <address> [n], quit")
+	}
+
+	if err != nil {
+		fmt.Fprintln(this.writer, err)
+	}
+
+	return false
+}
+
+func (this *DebugSession) Count(arguments []string) (uint64, error) {
+	if len(arguments) == 0 {
+		return 1, nil
+	}
+
+	return strconv.ParseUint(arguments[0], 0, 64)
+}
+
+/* an address, or a label of the source map */
+func (this *DebugSession) Location(location string) (uint16, error) {
+	if this.sourceMap != nil {
+		if label := this.sourceMap.Label(location); label != nil {
+			return label.Address, nil
+		}
+	}
+
+	address, err := strconv.ParseUint(location, 0, 16)
+
+	if err != nil {
+		return 0, errors.New("unknown location: " + location)
+	}
+
+	return uint16(address), nil
+}
+
+func (this *DebugSession) Describe(address uint16) string {
+	description := fmt.Sprintf("%04x %-24s", address, this.cpu.DisassembleAt(address))
+
+	if this.sourceMap != nil {
+		description += " " + this.sourceMap.Describe(address)
+	}
+
+	return description
+}
+
+func (this *DebugSession) Where() {
+	if !this.cpu.Running() {
+		fmt.Fprintln(this.writer, "program exited with code", this.cpu.ExitCode())
+		return
+	}
+
+	fmt.Fprintln(this.writer, this.Describe(this.cpu.Register(isa.RegisterEncodingIP)))
+}
+
+/* executes count cycles, or until a breakpoint when toBreakpoint */
+func (this *DebugSession) Forward(count uint64, toBreakpoint bool) error {
+	defer this.Where()
+
+	for step := uint64(0); toBreakpoint || step < count; step++ {
+		if !this.cpu.Running() {
+			return nil
+		}
+
+		if toBreakpoint && step != 0 && slices.Contains(this.breakpoints, this.cpu.Register(isa.RegisterEncodingIP)) {
+			return nil
+		}
+
+		err := this.cpu.Cycle()
+		this.cpu.FlushOutput()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* undoes count cycles, or until a breakpoint when toBreakpoint */
+func (this *DebugSession) Backward(count uint64, toBreakpoint bool) error {
+	if this.history == nil {
+		return errors.New("no history recorded, start the session with --history")
+	}
+
+	defer this.Where()
+
+	for step := uint64(0); toBreakpoint || step < count; step++ {
+		if toBreakpoint && step != 0 && slices.Contains(this.breakpoints, this.cpu.Register(isa.RegisterEncodingIP)) {
+			return nil
+		}
+
+		if this.history.Back(this.cpu) == nil {
+			return errors.New("reached the start of the history")
+		}
+	}
+
+	return nil
+}
+
+func (this *DebugSession) LastWrite(target string) error {
+	if this.history == nil {
+		return errors.New("no history recorded, start the session with --history")
+	}
+
+	if register, err := isa.RegisterAsInt(target); err == nil {
+		record, previous, value := this.history.LastRegisterWrite(this.cpu, register)
+
+		if record == nil {
+			return fmt.Errorf("%s was not written in the last %d steps", target, this.history.Len())
+		}
+
+		fmt.Fprintf(this.writer, "step %d: %s (%s: %d -> %d)\n", record.Step, this.Describe(record.IP), target, previous, value)
+		return nil
+	}
+
+	address, err := this.Location(target)
+
+	if err != nil {
+		return err
+	}
+
+	record, write := this.history.LastMemoryWrite(address)
+
+	if record == nil {
+		return fmt.Errorf("%d was not written in the last %d steps", address, this.history.Len())
+	}
+
+	fmt.Fprintf(this.writer, "step %d: %s ([%d]: %d -> %d)\n", record.Step, this.Describe(record.IP), address, write.Previous, write.Value)
+	return nil
+}
+
+/* loads the program or restores the snapshot like Execute and hands it to a debug session reading commands from stdin */
+func Debug(path string, arguments []string, options ExecuteOptions, historySize int) int {
+	/* the session waits on its commands, a clock running meanwhile would stop it at random */
+	if options.limits.Timeout != 0 {
+		fmt.Fprintln(os.Stderr, "--timeout can't be used with debug")
+		return ExitCodeUsage
+	}
+
+	cpu := vm.NewCPU(options.debug)
+	var err error
+	var sourceMap *asm.SourceMap
+
+	if options.restore != "" {
+		err = cpu.RestoreSnapshot(options.restore)
+	} else {
+		err = cpu.LoadProgramFromFile(path)
+	}
+
+	if err == nil && path != "" {
+		sourceMap, err = LoadSourceMap(path)
+	}
+
+	release := func() {}
+
+	if err == nil {
+		release, err = options.Configure(cpu, sourceMap)
+	}
+
+	defer release()
+
+	/* a restored machine carries on where it was saved */
+	if err == nil && options.restore == "" {
+		err = cpu.Start(arguments)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCodeError
+	}
+
+	defer cpu.CloseFiles()
+	NewDebugSession(cpu, sourceMap, historySize, os.Stdout).Loop(os.Stdin)
+
+	/* a replay the session didn't go through to its end is reported like one that diverged */
+	if err := cpu.FinishRecording(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCodeError
+	}
+
+	return int(cpu.ExitCode())
+}
@@ -1,7 +0,0 @@
-package main
-
-const (
-    SyscallReset = 0
-    SyscallExit = 1
-    SyscallWrite = 4
-)
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
+)
+
+/*
+/
+/ Tests:
+/	"nfasm test dir/" assembles every .s file under dir and runs each test_ label of the program on a fresh cpu, the
+/	included files' labels too (once, when several files include them), called by a stub appended to the program, so a
+/	test either returns with ret or exits with the exit syscall
+/
+/	a test passes when no error stopped it (failed assert syscalls included, see syscalls.go), its exit code matches
+/	(0 unless declared) and its stdout matches, when declared, expectations are comments on the lines directly above
+/	the label:
+/		// expect stdout "hello\n"
+/		// expect exit 3
+/		test_hello:
+/
+*/
+
+const (
+	TestLabelPrefix = "test_"
+	TestStepLimit   = 1000000
+)
+
+var (
+	TestDirectivePattern = regexp.MustCompile(`^\s*//\s*expect\s+(stdout|exit)\s+(.*?)\s*$`)
+)
+
+type TestCase struct {
+	path, name string
+	span       asm.Span // of the label, in the file declaring it
+	stdout     *string
+	exit       int
+}
+
+type TestResult struct {
+	test     *TestCase
+	location string
+	steps    uint64
+	err      error
+}
+
+type TestOptions struct {
+	includes []string
+	parallel int
+	maxSteps uint64
+	verbose  bool
+}
+
+/* the .s files under the paths, paths naming files are taken as they are */
+func FindTestFiles(paths []string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if file == path && !entry.IsDir() || !entry.IsDir() && filepath.Ext(file) == ".s" {
+				files = append(files, file)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+/* whether the file could hold or include tests, the others aren't assembled (they may be fragments of a program) */
+func MayDeclareTests(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(string(content), TestLabelPrefix) || strings.Contains(string(content), "include"), nil
+}
+
+/* the test_ labels of the assembled program, whichever file declared them, with the expectations written right above them */
+func FindTestCases(path string, program *asm.Program) ([]*TestCase, error) {
+	spans := make(map[string]asm.Span)
+
+	for _, ast := range program.Tree() {
+		if ast.Kind() == asm.AstLabel {
+			spans[ast.Name()] = ast.Span()
+		}
+	}
+
+	sources := make(map[string][]string)
+	var tests []*TestCase
+
+	for _, label := range program.SourceMap().Labels() {
+		span, found := spans[label.Name]
+
+		if !strings.HasPrefix(label.Name, TestLabelPrefix) || !found {
+			continue
+		}
+
+		if sources[span.Stream] == nil {
+			content, err := os.ReadFile(span.Stream)
+
+			if err != nil {
+				return nil, err
+			}
+
+			sources[span.Stream] = strings.Split(string(content), "\n")
+		}
+
+		test := &TestCase{path, label.Name, span, nil, 0}
+
+		if err := test.ParseDirectives(sources[span.Stream]); err != nil {
+			return nil, err
+		}
+
+		tests = append(tests, test)
+	}
+
+	return tests, nil
+}
+
+/* reads the directives on the lines directly above the label, up to the first line that isn't one */
+func (this *TestCase) ParseDirectives(lines []string) error {
+	for row := int(this.span.Row) - 1; row >= 1 && row <= len(lines); row-- {
+		match := TestDirectivePattern.FindStringSubmatch(lines[row-1])
+
+		if match == nil {
+			return nil
+		}
+
+		invalid := asm.NewSourceError(asm.NewSpan(this.span.Stream, 0, uint64(row), 1, 0), errors.New("invalid expected "+match[1]+": "+match[2]))
+
+		switch match[1] {
+		case "stdout":
+			stdout, err := strconv.Unquote(match[2])
+
+			if err != nil {
+				return invalid
+			}
+
+			this.stdout = &stdout
+
+		case "exit":
+			exit, err := strconv.Atoi(match[2])
+
+			if err != nil {
+				return invalid
+			}
+
+			this.exit = exit
+		}
+	}
+
+	return nil
+}
+
+/* runs the test on a fresh cpu, program being the assembled file */
+func RunTestCase(test *TestCase, program *asm.Program, options TestOptions) TestResult {
+	result := TestResult{test, test.path, 0, nil}
+	sourceMap := program.SourceMap()
+	var stdout, stderr bytes.Buffer
+
+	cpu, err := vm.New(vm.Options{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: &stderr, Limits: vm.Limits{MaxSteps: options.maxSteps}})
+
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	label := sourceMap.Label(test.name)
+
+	if label == nil {
+		result.err = errors.New("test label not found: " + test.name)
+		return result
+	}
+
+	result.location = sourceMap.Describe(label.Address)
+
+	if result.err = cpu.LoadProgramFromMemory(program.Words()); result.err != nil {
+		return result
+	}
+
+	lexer := asm.NewLexer("<test>", fmt.Sprintf("call %d\nmov a, %d\nmov b, 0\nsyscall\n", label.Address, vm.SyscallExit))
+	parser, err := asm.NewParser(&lexer)
+
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	stub, err := parser.Parse()
+
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	generation, err := asm.GenerateAt(stub, cpu.ProgramSize(), &[]asm.Label{})
+
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	entry, err := cpu.AppendProgram(generation)
+
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	cpu.SetRegister(isa.RegisterEncodingIP, entry)
+	err = cpu.Run(context.Background(), []string{test.path})
+	result.steps = cpu.Steps()
+	var executionError *vm.ExecutionError
+
+	switch {
+	case errors.As(err, &executionError):
+		result.err = fmt.Errorf("%s: %w", sourceMap.Describe(executionError.IP), err)
+	case err != nil:
+		result.err = err
+	case int(cpu.ExitCode()) != test.exit:
+		result.err = fmt.Errorf("exit code %d, expected %d", cpu.ExitCode(), test.exit)
+	case test.stdout != nil && stdout.String() != *test.stdout:
+		result.err = fmt.Errorf("stdout %q, expected %q", stdout.String(), *test.stdout)
+	}
+
+	if result.err != nil && stderr.Len() != 0 {
+		result.err = fmt.Errorf("%w\nstderr:\n%s", result.err, stderr.String())
+	}
+
+	return result
+}
+
+/* runs the tests of every file, options.parallel at a time, prints the results in order and returns whether they all passed */
+func RunTests(writer io.Writer, paths []string, options TestOptions) (bool, error) {
+	files, err := FindTestFiles(paths)
+
+	if err != nil {
+		return false, err
+	}
+
+	type job struct {
+		test    *TestCase
+		program *asm.Program
+		err     error
+	}
+
+	var jobs []job
+
+	/* a test of a file included by others runs once, from the first file it was found in */
+	seen := make(map[asm.Span]bool)
+
+	for _, file := range files {
+		if candidate, err := MayDeclareTests(file); err != nil {
+			return false, err
+		} else if !candidate {
+			continue
+		}
+
+		program, err := asm.AssembleFile(file, options.includes)
+
+		if err != nil {
+			jobs = append(jobs, job{&TestCase{file, "assembly", asm.Span{}, nil, 0}, nil, err})
+			continue
+		}
+
+		tests, err := FindTestCases(file, &program)
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, test := range tests {
+			if !seen[test.span] {
+				seen[test.span] = true
+				jobs = append(jobs, job{test, &program, nil})
+			}
+		}
+	}
+
+	results := make([]TestResult, len(jobs))
+	semaphore := make(chan struct{}, max(options.parallel, 1))
+	var group sync.WaitGroup
+
+	for index, job := range jobs {
+		if job.err != nil {
+			results[index] = TestResult{job.test, job.test.path, 0, job.err}
+			continue
+		}
+
+		group.Add(1)
+		semaphore <- struct{}{}
+
+		go func() {
+			defer group.Done()
+			results[index] = RunTestCase(job.test, job.program, options)
+			<-semaphore
+		}()
+	}
+
+	group.Wait()
+
+	var failed int
+
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			fmt.Fprintf(writer, "FAIL %s %s: %v\n", result.test.name, result.location, result.err)
+		} else if options.verbose {
+			fmt.Fprintf(writer, "ok   %s %s (%d steps)\n", result.test.name, result.location, result.steps)
+		}
+	}
+
+	fmt.Fprintf(writer, "%d passed, %d failed\n", len(results)-failed, failed)
+	return failed == 0, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"nfasm/asm"
+	"nfasm/isa"
+	"nfasm/vm"
+)
+
+/*
+/
+/ Traces:
+/	"exe --trace file.jsonl" writes one json object per executed cycle:
+/		{"step": 3, "ip": 8, "instruction": "cmp d, 3", "executed": true, "label": "main",
+/		 "registers": {"a": [0, 4]}, "flags": {"zero": [false, true]},
+/		 "writes": [{"address": 4095, "previous": 0, "value": 2}], "error": "..."}
+/	registers and flags only list what changed, label is only there when a source map is loaded
+/
+/	"nfasm trace" filters a trace (--from, --to, --label, --register) and prints it or, with --summary, sums it up
+/
+*/
+
+type TraceEntry struct {
+	Step        uint64               `json:"step"`
+	IP          uint16               `json:"ip"`
+	Instruction string               `json:"instruction"`
+	Executed    bool                 `json:"executed"`
+	Label       string               `json:"label,omitempty"`
+	Registers   map[string][2]uint16 `json:"registers,omitempty"`
+	Flags       map[string][2]bool   `json:"flags,omitempty"`
+	Writes      []TraceWrite         `json:"writes,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+type TraceWrite struct {
+	Address  uint16 `json:"address"`
+	Previous uint16 `json:"previous"`
+	Value    uint16 `json:"value"`
+}
+
+type Tracer struct {
+	writer    *bufio.Writer
+	encoder   *json.Encoder
+	sourceMap *asm.SourceMap
+	err       error // the first failed write, entries after it are dropped
+}
+
+/* sourceMap names the labels of the entries, it may be nil */
+func NewTracer(writer io.Writer, sourceMap *asm.SourceMap) *Tracer {
+	buffered := bufio.NewWriter(writer)
+	return &Tracer{buffered, json.NewEncoder(buffered), sourceMap, nil}
+}
+
+var TraceFlags = []struct {
+	register uint16
+	state    uint16
+	name     string
+}{
+	{isa.RegisterEncodingUSR, isa.UserStateZero, "zero"},
+	{isa.RegisterEncodingUSR, isa.UserStateCarry, "carry"},
+	{isa.RegisterEncodingUSR, isa.UserStateOverflow, "overflow"},
+	{isa.RegisterEncodingRSR, isa.ReservedStateRunning, "running"},
+	{isa.RegisterEncodingRSR, isa.ReservedStateInterrupts, "interrupts"},
+}
+
+func (this *Tracer) Observe(cpu *vm.CPU, record *vm.StepRecord) {
+	if this.err != nil {
+		return
+	}
+
+	entry := TraceEntry{record.Step, record.IP, cpu.DisassembleAt(record.IP), record.Executed, "", nil, nil, nil, ""}
+	after := cpu.RegisterValues()
+
+	if this.sourceMap != nil {
+		if label := this.sourceMap.EnclosingLabel(record.IP); label != nil {
+			entry.Label = label.Name
+		}
+	}
+
+	for index := range after {
+		if index == isa.RegisterEncodingIP || index == isa.RegisterEncodingOPAR || index == isa.RegisterEncodingUSAR || index == isa.RegisterEncodingUSR || index == isa.RegisterEncodingRSR {
+			continue
+		}
+
+		if record.Before[index] != after[index] {
+			if entry.Registers == nil {
+				entry.Registers = make(map[string][2]uint16)
+			}
+
+			name, _ := isa.RegisterAsString(uint16(index))
+			entry.Registers[name] = [2]uint16{record.Before[index], after[index]}
+		}
+	}
+
+	for _, flag := range TraceFlags {
+		previous, current := record.Before[flag.register]&flag.state != 0, after[flag.register]&flag.state != 0
+
+		if previous != current {
+			if entry.Flags == nil {
+				entry.Flags = make(map[string][2]bool)
+			}
+
+			entry.Flags[flag.name] = [2]bool{previous, current}
+		}
+	}
+
+	for _, write := range record.Writes {
+		entry.Writes = append(entry.Writes, TraceWrite{write.Address, write.Previous, write.Value})
+	}
+
+	if record.Err != nil {
+		entry.Error = record.Err.Error()
+	}
+
+	this.err = this.encoder.Encode(entry)
+}
+
+/* reports the first write that failed, a trace that couldn't be written completely is incomplete */
+func (this *Tracer) Flush() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	return this.writer.Flush()
+}
+
+type TraceFilter struct {
+	from, to  uint16
+	label     string
+	register  string
+	sourceMap *asm.SourceMap
+}
+
+func (this *TraceFilter) Matches(entry *TraceEntry) bool {
+	if entry.IP < this.from || entry.IP > this.to {
+		return false
+	}
+
+	if this.label != "" {
+		if this.sourceMap != nil {
+			label := this.sourceMap.EnclosingLabel(entry.IP)
+
+			if label == nil || label.Name != this.label {
+				return false
+			}
+		} else if entry.Label != this.label {
+			return false
+		}
+	}
+
+	if this.register != "" {
+		if _, changed := entry.Registers[this.register]; !changed {
+			return false
+		}
+	}
+
+	return true
+}
+
+func FormatTraceEntry(entry *TraceEntry) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%6d %04x %-24s", entry.Step, entry.IP, entry.Instruction)
+
+	if !entry.Executed {
+		builder.WriteString(" (skipped)")
+	}
+
+	for _, name := range SortedKeys(entry.Registers) {
+		fmt.Fprintf(&builder, " %s: %d -> %d", name, entry.Registers[name][0], entry.Registers[name][1])
+	}
+
+	for _, name := range SortedKeys(entry.Flags) {
+		fmt.Fprintf(&builder, " %s: %t -> %t", name, entry.Flags[name][0], entry.Flags[name][1])
+	}
+
+	for _, write := range entry.Writes {
+		fmt.Fprintf(&builder, " [%d]: %d -> %d", write.Address, write.Previous, write.Value)
+	}
+
+	if entry.Error != "" {
+		builder.WriteString(" error: " + entry.Error)
+	}
+
+	return builder.String()
+}
+
+func SortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+/* prints the entries of the trace at path matching filter, or a summary of them */
+func ReadTrace(writer io.Writer, path string, filter TraceFilter, summary bool) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	var total, matched, skipped, writes uint64
+	instructions, registers := make(map[string]uint64), make(map[string]uint64)
+
+	for {
+		var entry TraceEntry
+
+		if err := decoder.Decode(&entry); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		total++
+
+		if !filter.Matches(&entry) {
+			continue
+		}
+
+		matched++
+
+		if !summary {
+			fmt.Fprintln(writer, FormatTraceEntry(&entry))
+			continue
+		}
+
+		if !entry.Executed {
+			skipped++
+		}
+
+		instructions[strings.SplitN(entry.Instruction, " ", 2)[0]]++
+		writes += uint64(len(entry.Writes))
+
+		for name := range entry.Registers {
+			registers[name]++
+		}
+	}
+
+	if summary {
+		fmt.Fprintf(writer, "steps: %d (%d matching, %d skipped by their user states)\nmemory writes: %d\n", total, matched, skipped, writes)
+		fmt.Fprintln(writer, "instructions:")
+
+		for _, name := range SortedKeys(instructions) {
+			fmt.Fprintf(writer, "    %-10s %d\n", name, instructions[name])
+		}
+
+		fmt.Fprintln(writer, "register changes:")
+
+		for _, name := range SortedKeys(registers) {
+			fmt.Fprintf(writer, "    %-10s %d\n", name, registers[name])
+		}
+	}
+
+	return nil
+}
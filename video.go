@@ -1,27 +0,0 @@
-package main
-
-const (
-    VideoScreenWidth = 256
-    VideoScreenHeight = 240
-    VideoMemorySize = VideoScreenWidth * VideoScreenHeight
-)
-
-/*
-/	   ┌───────────────────────────────────────────────────────────────────────────────┬───┬───┬───┐
-/    	   │ 󰍛 Video Memory      							   │ 󰖰 │ 󰖯 │ 󰖭 │
-/          ├───────────────────────────────────────────────────────────────────────────────┴───┴───┴───┤
-/          │  											       │
-/          │   Not Implemented Yet								       │
-/	   │											       │
-/          │  Video memory or VRAM that would be controlled wih another component i would implement w  │
-/          │  hich is the graphics processing unit, also known as GPU, that would interchangably inte  │
-/          │  ract with the central processing unit or the CPU, the screen size would be 256 pixels w  │
-/          │  idth by 240 pixels height, which is the same resolution that is used in the nintendo en  │
-/          │  tertainment system or the NES, the total video memory size would then be 61440 pixels.   │
-/          │  								                               │
-/          │  				   	    ┌──────┐	                                       │
-/          │  				   	    │  Ok  │		                               │
-/          │  				            └──────┘		                               │
-/          │  			        		   🮰	                                       │
-/          └───────────────────────────────────────────────────────────────────────────────────────────┘
-*/
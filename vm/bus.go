@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/* addresses given to a device are relative to the start of its mapping */
+type Device interface {
+	Read(offset uint16) (uint16, error)
+	Write(offset, value uint16) error
+}
+
+type BusMapping struct {
+	name   string
+	start  uint16
+	size   int
+	device Device
+}
+
+/*
+/
+/ Bus:
+/	the 16 bit address space, address ranges are claimed by devices, unclaimed addresses fault with a segment violation
+/
+/	default memory map:
+/		0x0000-0x0fff ram (text, data, heap and stack segments, see memory.go)
+/		0x1000-0x17d2 console, see console.go
+/		0xff00-0xff02 timer, see timer.go
+/		0xff08-0xff09 gpu, see video.go
+/		0xff10-0xff12 keyboard, see keyboard.go
+/
+*/
+
+type Bus struct {
+	mappings []BusMapping
+}
+
+func NewBus() Bus {
+	return Bus{nil}
+}
+
+func (this *Bus) Attach(name string, start uint16, size int, device Device) error {
+	if size <= 0 || int(start)+size > 0x10000 {
+		return errors.New("failed to attach " + name + ": range out of the address space")
+	}
+
+	for _, mapping := range this.mappings {
+		if int(start) < int(mapping.start)+mapping.size && int(mapping.start) < int(start)+size {
+			return errors.New("failed to attach " + name + ": range overlaps " + mapping.name)
+		}
+	}
+
+	this.mappings = append(this.mappings, BusMapping{name, start, size, device})
+	return nil
+}
+
+func (this *Bus) Find(address uint16) *BusMapping {
+	for index := range this.mappings {
+		mapping := &this.mappings[index]
+
+		if address >= mapping.start && int(address) < int(mapping.start)+mapping.size {
+			return mapping
+		}
+	}
+
+	return nil
+}
+
+func (this *Bus) MemoryMap() string {
+	var builder strings.Builder
+
+	for _, mapping := range this.mappings {
+		builder.WriteString(fmt.Sprintf("0x%04x-0x%04x %s\n", mapping.start, int(mapping.start)+mapping.size-1, mapping.name))
+	}
+
+	return builder.String()
+}
+
+type Ram struct {
+	memory []uint16
+}
+
+func NewRam(memory []uint16) *Ram {
+	return &Ram{memory}
+}
+
+func (this *Ram) Read(offset uint16) (uint16, error) {
+	if int(offset) >= len(this.memory) {
+		return 0, errors.New("ram read out of bounds")
+	}
+
+	return this.memory[offset], nil
+}
+
+func (this *Ram) Write(offset, value uint16) error {
+	if int(offset) >= len(this.memory) {
+		return errors.New("ram write out of bounds")
+	}
+
+	this.memory[offset] = value
+	return nil
+}
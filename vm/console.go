@@ -0,0 +1,248 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	ConsoleAddress = 0x1000
+	ConsoleColumns = 80
+	ConsoleRows    = 25
+	ConsoleCells   = ConsoleColumns * ConsoleRows
+
+	ConsoleRegisterCursorX = ConsoleCells + 0
+	ConsoleRegisterCursorY = ConsoleCells + 1
+	ConsoleRegisterControl = ConsoleCells + 2
+	ConsoleSize            = ConsoleCells + 3
+
+	ConsoleControlCursor  = 0x0001
+	ConsoleControlRefresh = 0x0002
+
+	ConsoleModeNone = ""
+	ConsoleModeANSI = "ansi"
+	ConsoleModeText = "text"
+)
+
+/*
+/
+/ Console:
+/	80 by 25 character cells memory mapped from ConsoleAddress, row by row, one word per cell:
+/		[attribute: 8 bits, character: 8 bits]
+/	the attribute holds the background color in its high nibble and the foreground color in its low one,
+/	colors being the 16 ansi ones (0 black, 7 white, 8-15 bright), an attribute of 0 is drawn with the terminal defaults
+/
+/	registers (following the cells, ConsoleAddress + 2000):
+/		+0 cursor x
+/		+1 cursor y
+/		+2 control: [refresh, cursor visible], writing the refresh bit renders the console
+/
+/	rendering goes to a writer either with ansi escapes (redrawing the terminal) or as plain text (headless),
+/	a console modified since its last render is rendered once more when the program stops, rendered bytes count
+/	against the output limit (see limits.go)
+/
+/	writing "hi" at the top left corner in white on blue:
+/		mov a, 4096
+/		str a, 18280	// 0x4768
+/		inc a
+/		str a, 18281
+/
+*/
+
+type Console struct {
+	cells            []uint16
+	cursorX, cursorY uint16
+	control          uint16
+	writer           io.Writer
+	mode             string
+	dirty            bool
+	charge           func(count uint64) error // counts the rendered bytes against the output limit
+}
+
+func NewConsole(charge func(count uint64) error) Console {
+	cells := make([]uint16, ConsoleCells)
+
+	for index := range cells {
+		cells[index] = ' '
+	}
+
+	return Console{cells, 0, 0, 0, nil, ConsoleModeNone, false, charge}
+}
+
+func (this *Console) SetOutput(writer io.Writer, mode string) error {
+	if mode != ConsoleModeNone && mode != ConsoleModeANSI && mode != ConsoleModeText {
+		return errors.New("unsupported console mode: " + mode)
+	}
+
+	this.writer, this.mode = writer, mode
+	return nil
+}
+
+func (this *Console) Read(offset uint16) (uint16, error) {
+	switch {
+	case offset < ConsoleCells:
+		return this.cells[offset], nil
+
+	case offset == ConsoleRegisterCursorX:
+		return this.cursorX, nil
+
+	case offset == ConsoleRegisterCursorY:
+		return this.cursorY, nil
+
+	case offset == ConsoleRegisterControl:
+		return this.control, nil
+
+	default:
+		return 0, errors.New("console register out of bounds")
+	}
+}
+
+func (this *Console) Write(offset, value uint16) error {
+	switch {
+	case offset < ConsoleCells:
+		this.cells[offset] = value
+
+	case offset == ConsoleRegisterCursorX:
+		this.cursorX = value % ConsoleColumns
+
+	case offset == ConsoleRegisterCursorY:
+		this.cursorY = value % ConsoleRows
+
+	case offset == ConsoleRegisterControl:
+		this.control = value &^ ConsoleControlRefresh
+
+		if value&ConsoleControlRefresh != 0x0000 {
+			this.dirty = true
+			return this.Render()
+		}
+
+	default:
+		return errors.New("console register out of bounds")
+	}
+
+	this.dirty = true
+	return nil
+}
+
+func (this *Console) Text() string {
+	var builder strings.Builder
+
+	for row := 0; row < ConsoleRows; row++ {
+		line := make([]byte, ConsoleColumns)
+
+		for column := range line {
+			line[column] = this.Printable(this.cells[row*ConsoleColumns+column])
+		}
+
+		builder.WriteString(strings.TrimRight(string(line), " "))
+		builder.WriteByte('\n')
+	}
+
+	return builder.String()
+}
+
+func (this *Console) Printable(cell uint16) byte {
+	character := byte(cell & 0xff)
+
+	if character < ' ' || character > '~' {
+		return ' '
+	}
+
+	return character
+}
+
+func (this *Console) ANSI() string {
+	var builder strings.Builder
+	builder.WriteString("\x1b[?25l\x1b[H")
+
+	for row := 0; row < ConsoleRows; row++ {
+		attribute := -1
+
+		for column := 0; column < ConsoleColumns; column++ {
+			cell := this.cells[row*ConsoleColumns+column]
+
+			if int(cell>>8) != attribute {
+				attribute = int(cell >> 8)
+				builder.WriteString(ANSIAttribute(uint8(attribute)))
+			}
+
+			builder.WriteByte(this.Printable(cell))
+		}
+
+		builder.WriteString("\x1b[0m\r\n")
+	}
+
+	builder.WriteString(fmt.Sprintf("\x1b[%d;%dH", this.cursorY+1, this.cursorX+1))
+
+	if this.control&ConsoleControlCursor != 0x0000 {
+		builder.WriteString("\x1b[?25h")
+	}
+
+	return builder.String()
+}
+
+func ANSIAttribute(attribute uint8) string {
+	if attribute == 0 {
+		return "\x1b[0m"
+	}
+
+	foreground, background := int(attribute&0x0f), int(attribute>>4)
+	foregroundCode, backgroundCode := 30+foreground, 40+background
+
+	if foreground >= 8 {
+		foregroundCode = 90 + foreground - 8
+	}
+
+	if background >= 8 {
+		backgroundCode = 100 + background - 8
+	}
+
+	return fmt.Sprintf("\x1b[0;%d;%dm", foregroundCode, backgroundCode)
+}
+
+func (this *Console) Render() error {
+	if this.writer == nil || this.mode == ConsoleModeNone || !this.dirty {
+		return nil
+	}
+
+	this.dirty = false
+	output := this.Text()
+
+	if this.mode == ConsoleModeANSI {
+		output = this.ANSI()
+	}
+
+	if err := this.charge(uint64(len(output))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(this.writer, output)
+	return err
+}
+
+/* the cells followed by the cursor and control registers, the layout of the device itself */
+func (this *Console) Snapshot() []uint16 {
+	return append(slices.Clone(this.cells), this.cursorX, this.cursorY, this.control)
+}
+
+func (this *Console) Validate(state []uint16) error {
+	if len(state) != ConsoleSize {
+		return errors.New("failed to restore console: invalid state size")
+	}
+
+	return nil
+}
+
+func (this *Console) Restore(state []uint16) error {
+	if err := this.Validate(state); err != nil {
+		return err
+	}
+
+	copy(this.cells, state[:ConsoleCells])
+	this.cursorX, this.cursorY, this.control = state[ConsoleRegisterCursorX], state[ConsoleRegisterCursorY], state[ConsoleRegisterControl]
+	this.dirty = true
+	return nil
+}
@@ -0,0 +1,596 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"nfasm/isa"
+)
+
+type CPU struct {
+	mainMemory                                                           []uint16
+	videoMemory                                                          []uint16
+	programSize, a, b, c, d, e, ip, lr, dp, hp, sp, usr, rsr, opar, usar uint16
+	registers                                                            []*uint16
+	debugger                                                             Debugger
+	instructionIp                                                        uint16
+	pendingInterrupts                                                    atomic.Uint32
+	timer                                                                Timer
+	bus                                                                  Bus
+	gpu                                                                  GPU
+	console                                                              Console
+	keyboard                                                             *Keyboard
+	stdin                                                                io.Reader
+	sandbox                                                              string
+	files                                                                map[uint16]*os.File
+	stdout, stderr                                                       *bufio.Writer
+	heap                                                                 Heap
+	environment                                                          []string
+	steps, written                                                       uint64
+	limits                                                               Limits
+	observers                                                            []Observer
+	record                                                               *StepRecord
+	recording                                                            *Recording
+	syscalls                                                             map[uint16]SyscallHandler
+}
+
+func NewCPU(debug bool) *CPU {
+	cpu := &CPU{
+		make([]uint16, isa.MemorySize),
+		make([]uint16, VideoMemorySize),
+		0, 0, 0, 0, 0, 0, isa.SegmentTextStart, isa.SegmentTextStart, isa.InterruptVectorStart + isa.InterruptVectorCount, isa.SegmentHeapStart, isa.SegmentStackStart, isa.UserStateDefault, isa.ReservedStateDefault, 0, 0,
+		make([]*uint16, 14),
+		NewDebugger(debug),
+		0,
+		atomic.Uint32{},
+		NewTimer(),
+		NewBus(),
+		GPU{},
+		Console{},
+		nil,
+		os.Stdin,
+		"",
+		make(map[uint16]*os.File),
+		bufio.NewWriter(os.Stdout),
+		bufio.NewWriter(os.Stderr),
+		NewHeap(),
+		nil,
+		0, 0,
+		Limits{},
+		nil,
+		nil,
+		nil,
+		make(map[uint16]SyscallHandler),
+	}
+
+	cpu.keyboard = NewKeyboard(cpu.RaiseExternalInterrupt)
+
+	cpu.console = NewConsole(cpu.CheckOutput)
+	cpu.gpu = NewGPU(cpu.videoMemory, cpu.CheckOutput)
+
+	cpu.bus.Attach("ram", 0x0000, isa.MemorySize, NewRam(cpu.mainMemory))
+	cpu.bus.Attach("console", ConsoleAddress, ConsoleSize, &cpu.console)
+	cpu.bus.Attach("timer", TimerAddress, TimerRegisterCount, &cpu.timer)
+	cpu.bus.Attach("gpu", GPUAddress, GPURegisterCount, &cpu.gpu)
+	cpu.bus.Attach("keyboard", KeyboardAddress, KeyboardRegisterCount, cpu.keyboard)
+	cpu.LoadRegisters()
+	return cpu
+}
+
+/* the value of a register by its encoding, which has to be below isa.RegisterEncodingCount, see isa/registers.go */
+func (this *CPU) Register(encoding uint16) uint16 {
+	return *this.registers[encoding]
+}
+
+func (this *CPU) SetRegister(encoding, value uint16) {
+	*this.registers[encoding] = value
+}
+
+func (this *CPU) Running() bool {
+	return this.rsr&isa.ReservedStateRunning != 0x0000
+}
+
+/* marks the program running again after it exited, so it can be stepped further */
+func (this *CPU) SetRunning(running bool) {
+	if running {
+		this.rsr |= isa.ReservedStateRunning
+	} else {
+		this.rsr &^= isa.ReservedStateRunning
+	}
+}
+
+/* the exit code of a program that stopped with the exit syscall, b holds it */
+func (this *CPU) ExitCode() uint16 {
+	return this.b
+}
+
+/* the number of instructions stepped so far */
+func (this *CPU) Steps() uint64 {
+	return this.steps
+}
+
+func (this *CPU) ProgramSize() uint16 {
+	return this.programSize
+}
+
+func (this *CPU) Input() io.Reader {
+	return this.stdin
+}
+
+func (this *CPU) Keyboard() *Keyboard {
+	return this.keyboard
+}
+
+func (this *CPU) GPU() *GPU {
+	return &this.gpu
+}
+
+func (this *CPU) Console() *Console {
+	return &this.console
+}
+
+func (this *CPU) Debugger() *Debugger {
+	return &this.debugger
+}
+
+func (this *CPU) MemoryMap() string {
+	return this.bus.MemoryMap()
+}
+
+/* replaces the reader behind the read syscall on stdin, os.Stdin by default */
+func (this *CPU) SetInput(reader io.Reader) {
+	this.stdin = reader
+}
+
+/* replaces the writers behind file descriptors 1 and 2, os.Stdout and os.Stderr by default, both are buffered */
+func (this *CPU) SetOutput(stdout, stderr io.Writer) {
+	this.stdout, this.stderr = bufio.NewWriter(stdout), bufio.NewWriter(stderr)
+}
+
+/* called before reading stdin too, so a prompt shows before the program waits for its answer */
+func (this *CPU) FlushOutput() error {
+	if err := this.stdout.Flush(); err != nil {
+		return err
+	}
+
+	return this.stderr.Flush()
+}
+
+/* maps a device from go, see bus.go */
+func (this *CPU) AttachDevice(name string, start uint16, size int, device Device) error {
+	return this.bus.Attach(name, start, size, device)
+}
+
+/* initializes basic registering system, as this.registers is used among different calls, *required to be called before running */
+func (this *CPU) LoadRegisters() {
+	this.registers = []*uint16{&this.a, &this.b, &this.c, &this.d, &this.e, &this.ip, &this.lr, &this.dp, &this.hp, &this.sp, &this.usr, &this.rsr, &this.opar, &this.usar}
+	this.debugger.Log("loaded registers: ", this.registers)
+}
+
+/*
+/
+/ Program entry:
+/	argv[0] is the program path, the program arguments follow it, envp holds "KEY=VALUE" strings
+/	every string is nul terminated and both arrays end with a 0 entry, all of them are allocated on the heap
+/
+/	at the first instruction:
+/		a = argc, b = argv, c = envp
+/		the stack holds, from its top: argc, argv, envp
+/
+*/
+func (this *CPU) LoadArguments(arguments []string) error {
+    argv, err := this.LoadStrings(arguments)
+
+    if err != nil {
+	return err
+    }
+
+    envp, err := this.LoadStrings(this.environment)
+
+    if err != nil {
+	return err
+    }
+
+    for _, value := range []uint16{envp, argv, uint16(len(arguments))} {
+	if err := this.PushValue(value); err != nil {
+	    return err
+	}
+    }
+
+    this.a, this.b, this.c = uint16(len(arguments)), argv, envp
+    return nil
+}
+
+/* allocates every string and a 0 terminated array of their addresses, returning the address of the array */
+func (this *CPU) LoadStrings(strings []string) (uint16, error) {
+    array, err := this.Alloc(uint16(len(strings) + 1))
+
+    if err != nil {
+	return 0, err
+    }
+
+    for index, value := range strings {
+	address, err := this.Allocate(uint16(len(value)))
+
+	if err != nil {
+	    return 0, err
+	}
+
+	for offset, character := range []byte(value) {
+	    this.mainMemory[int(address) + offset] = uint16(character)
+	}
+
+	this.mainMemory[int(array) + index] = address
+    }
+
+    this.mainMemory[int(array) + len(strings)] = 0
+    return array, nil
+}
+
+func (this *CPU) SetEnvironment(environment []string) {
+    this.environment = environment
+}
+
+/* allocates a nul terminated string of size characters on the heap */
+func (this *CPU) Allocate(size uint16) (uint16, error) {
+    address, err := this.Alloc(size + 1)
+
+    if err != nil {
+	return 0, err
+    }
+
+    this.mainMemory[address + size] = 0
+    return address, nil
+}
+
+func (this *CPU) ClearMemory() {
+	for i := 0; i < isa.MemorySize; i++ {
+		this.mainMemory[i] = 0x0000
+	}
+
+	this.debugger.Log("cleared memory: [0:", isa.MemorySize, "]")
+}
+
+func (this *CPU) ClearProgram() {
+	for i := 0; i < isa.SegmentTextSize; i++ {
+		this.mainMemory[i] = 0x0000
+	}
+
+	this.debugger.Log("cleared text segment: [", isa.SegmentTextStart, ":", isa.SegmentTextSize, "]")
+}
+
+func (this *CPU) LoadProgramFromMemory(program []uint16) error {
+	if len(program) >= isa.SegmentTextSize {
+		this.debugger.Log("failed to load program: ", program)
+		return errors.New("failed to load program: len(program) >= MemorySize")
+	} else {
+		for index, value := range program {
+			this.mainMemory[isa.SegmentTextStart+index] = value
+		}
+
+		this.programSize = uint16(len(program))
+		this.debugger.Log("loaded program: ", program, "size: ", len(program))
+		return nil
+	}
+}
+
+/* appends words after the loaded program, returning the address they start at */
+func (this *CPU) AppendProgram(program []uint16) (uint16, error) {
+	start := this.programSize
+
+	if int(start)+len(program) >= isa.SegmentTextSize {
+		return 0, errors.New("failed to append program: text segment full")
+	}
+
+	copy(this.mainMemory[isa.SegmentTextStart+int(start):], program)
+	this.programSize += uint16(len(program))
+	return start, nil
+}
+
+func (this *CPU) LoadProgramFromFile(path string) error {
+	program, err := isa.ReadProgram(path)
+
+	if err != nil {
+		return err
+	}
+
+	return this.LoadProgramFromMemory(program)
+}
+
+func (this *CPU) Fetch() (uint16, error) {
+	if this.ip >= isa.SegmentTextStart+this.programSize {
+		return 0, this.Raise(FaultIPOutOfBounds)
+	}
+
+	this.opar = this.mainMemory[isa.SegmentTextStart+this.ip]
+	this.debugger.Log("at:", this.ip, "fetched:", this.mainMemory[this.ip])
+	this.ip++
+	return this.opar, nil
+}
+
+func (this *CPU) Decode() error {
+	if this.ip >= isa.SegmentTextStart+this.programSize {
+		return this.Raise(FaultIPOutOfBounds)
+	}
+
+	this.usar = this.mainMemory[isa.SegmentTextStart+this.ip]
+	this.ip++
+	return nil
+}
+
+func (this *CPU) Execute() error {
+	if int(this.opar) >= len(Instructions) {
+		return this.Raise(FaultInvalidOpcode)
+	}
+
+	if this.record != nil {
+		this.record.Opcode = this.opar
+		this.record.Conditional = this.Conditioned()
+		this.record.Executed = !this.Conditioned() || this.UserStatesMatches()
+	}
+
+	if this.Conditioned() && !this.UserStatesMatches() {
+		/* the instruction is skipped, its operands still have to be stepped over */
+		operands, _ := isa.OpcodeOperandsCount(this.opar)
+		this.ip += operands
+		return nil
+	}
+
+	return Instructions[this.opar].Instruction(this)
+}
+
+/* runs a single instruction, errors carry the address and the disassembly of the instruction that caused them */
+func (this *CPU) Step() error {
+	if this.limits.MaxSteps != 0 && this.steps >= this.limits.MaxSteps {
+		return ErrStepLimit
+	}
+
+	this.instructionIp = this.ip
+	this.steps++
+
+	if this.record != nil {
+		this.record.IP = this.ip
+	}
+
+	_, err := this.Fetch()
+
+	if err == nil {
+		err = this.Decode()
+	}
+
+	if err == nil {
+		err = this.Execute()
+	}
+
+	if err != nil {
+		return NewExecutionError(this.instructionIp, this.DisassembleCurrent(), err)
+	}
+
+	this.timer.Tick(this)
+
+	if err := this.gpu.Tick(); err != nil {
+		return NewExecutionError(this.instructionIp, this.DisassembleCurrent(), err)
+	}
+
+	return nil
+}
+
+func (this *CPU) RegisterValues() []uint16 {
+	values := make([]uint16, len(this.registers))
+
+	for index, register := range this.registers {
+		values[index] = *register
+	}
+
+	return values
+}
+
+/* services pending interrupts then steps, delivering faults to their handlers */
+func (this *CPU) Cycle() error {
+	this.BeginRecord()
+	err := this.ExternalInterrupts()
+
+	if err == nil {
+		err = this.ServiceInterrupts()
+	}
+
+	if err == nil {
+		err = this.Step()
+	}
+
+	if err != nil {
+		err = this.ServiceFault(err)
+	}
+
+	this.EndRecord(err)
+	return err
+}
+
+func (this *CPU) DisassembleCurrent() string {
+	return this.DisassembleAt(this.instructionIp)
+}
+
+func (this *CPU) DisassembleAt(address uint16) string {
+	program := this.mainMemory[isa.SegmentTextStart : isa.SegmentTextStart+this.programSize]
+	text, _, err := isa.Disassemble(program, address)
+
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	return text
+}
+
+/* runs the program until it stops, ctx is cancelled or a limit is reached, see limits.go */
+func (this *CPU) Run(ctx context.Context, args []string) error {
+	if err := this.Start(args); err != nil {
+		return err
+	}
+
+	return this.Resume(ctx)
+}
+
+/* sets up the program entry without running anything, see LoadArguments */
+func (this *CPU) Start(args []string) error {
+	this.LoadRegisters()
+
+	if err := this.LoadArguments(args); err != nil {
+		return err
+	}
+
+	this.rsr |= isa.ReservedStateRunning
+	return nil
+}
+
+/* runs from the current state until the program stops, without setting up its entry, see RestoreSnapshot */
+func (this *CPU) Resume(ctx context.Context) (err error) {
+	defer this.CloseFiles()
+
+	/* a failed flush (a closed pipe, a full disk) is reported unless the program already failed */
+	defer func() {
+		if flush := this.FlushOutput(); err == nil {
+			err = flush
+		}
+	}()
+
+	this.debugger.Log("memory map:\n" + this.bus.MemoryMap())
+
+	if this.limits.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.limits.Timeout)
+		defer cancel()
+	}
+
+	for this.rsr&isa.ReservedStateRunning != 0x0000 {
+		err := this.CheckContext(ctx)
+
+		if err == nil {
+			err = this.Cycle()
+		}
+
+		if err != nil {
+			var executionError *ExecutionError
+
+			/* ip goes back to the failed instruction, a snapshot of the halted machine then retries it when resumed */
+			if errors.As(err, &executionError) {
+				this.ip = executionError.IP
+			}
+
+			this.debugger.Log("program halted:", err)
+			this.debugger.LogRegisters(&this.registers)
+			this.console.Render()
+			return err
+		}
+	}
+
+	if err := this.console.Render(); err != nil {
+		return err
+	}
+
+	return this.debugger.LogRegisters(&this.registers)
+}
+
+func (this *CPU) Conditioned() bool {
+	return this.usar&0xfffe != 0x0000
+}
+
+/* a condition matches when any of its states is on, negated conditions match when none of them is */
+func (this *CPU) UserStatesMatches() bool {
+	matches := (this.usar&isa.UserStateConditionMask)&(this.usr&isa.UserStateConditionMask) != 0x0000
+
+	if this.usar&isa.UserStateNegated != 0x0000 {
+		return !matches
+	}
+
+	return matches
+}
+
+func (this *CPU) GetRegisterReferenceFromEncoding() (*uint16, error) {
+	if this.registers[0] == nil {
+		return nil, errors.New("failed to get register value: registers not loaded")
+	}
+
+	register, err := this.Fetch()
+
+	if err != nil {
+		return nil, err
+	} else if register >= isa.RegisterEncodingCount {
+		return nil, this.Raise(FaultInvalidRegister)
+	} else {
+		return this.registers[register], nil
+	}
+}
+
+func (this *CPU) GetSource() (uint16, error) {
+	if this.usar&isa.UserStateImmediate != 0x0000 {
+		return this.Fetch()
+	} else {
+		register, err := this.GetRegisterReferenceFromEncoding()
+
+		if err != nil {
+			return 0, err
+		} else {
+			return *register, nil
+		}
+	}
+}
+
+func (this *CPU) ReadMemory(address uint16) (uint16, error) {
+	mapping := this.bus.Find(address)
+
+	if mapping == nil {
+		return 0, this.Raise(FaultSegmentViolation)
+	}
+
+	if volatile, ok := mapping.device.(VolatileDevice); ok && volatile.Volatile() && this.recording != nil {
+		return this.ReadVolatile(mapping, address)
+	}
+
+	return mapping.device.Read(address - mapping.start)
+}
+
+func (this *CPU) WriteMemory(address, value uint16) error {
+	mapping := this.bus.Find(address)
+
+	if mapping == nil {
+		return this.Raise(FaultSegmentViolation)
+	}
+
+	this.RecordWrite(mapping, address, value)
+	this.RecordStore(value)
+	return mapping.device.Write(address-mapping.start, value)
+}
+
+/* the stack grows down from SegmentStackStart and may not cross the heap break */
+func (this *CPU) PushValue(value uint16) error {
+	if this.sp <= this.hp {
+		return this.Raise(FaultStackOverflow)
+	}
+
+	this.sp--
+	return this.WriteMemory(this.sp, value)
+}
+
+func (this *CPU) PopValue() (uint16, error) {
+	if this.sp >= isa.SegmentStackStart {
+		return 0, this.Raise(FaultStackUnderflow)
+	}
+
+	value, err := this.ReadMemory(this.sp)
+
+	if err != nil {
+		return 0, err
+	}
+
+	this.sp++
+	return value, nil
+}
+
+func (this *CPU) Debug() {
+	fmt.Println("registers:\n\ta: ", this.a, "\n\tb: ", this.b, "\n\tc: ", this.c, "\n\td: ", this.d, "\n\te: ", this.e, "\n\tip: ", this.ip, "\n\tdp: ", this.dp, "\n\thp: ", this.hp, "\n\tsp: ", this.sp, "\n\tusr (states, user): ", this.usr, "\n\trsr (states, reserved): ", this.rsr, "\n\topar (opcode, addressing): ", this.opar, "\n\tusar (states, addressing): ", this.usar)
+}
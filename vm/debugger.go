@@ -1,6 +1,10 @@
-package main
+package vm
 
-import "fmt"
+import (
+	"fmt"
+
+	"nfasm/isa"
+)
 
 type Debugger struct {
     enabled bool
@@ -24,7 +28,7 @@ func (this *Debugger) LogRegisters(registers *[]*uint16) error {
     }
 
     for index, value := range *registers {
-	registerAsString, err := RegisterAsString(uint16(index))
+	registerAsString, err := isa.RegisterAsString(uint16(index))
 
 	if err != nil {
 	    return err
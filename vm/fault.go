@@ -0,0 +1,116 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"nfasm/isa"
+)
+
+const (
+	FaultDivideByZero = iota
+	FaultInvalidOpcode
+	FaultInvalidRegister
+	FaultStackOverflow
+	FaultStackUnderflow
+	FaultSegmentViolation
+	FaultIPOutOfBounds
+	FaultInvalidInterrupt
+	FaultCount
+)
+
+/* faulted programs exit with FaultExitCodeBase + <fault kind>, so they can be told apart from each other */
+const FaultExitCodeBase = 128
+
+/*
+/
+/ Faults:
+/	a fault stops the machine, it is made by Raise and returned by Run wrapped in an ExecutionError
+/	ip is the address of the faulting instruction (not the one after it), instruction is its opcode word
+/
+*/
+
+type Fault struct {
+	kind            int
+	ip, instruction uint16
+}
+
+func NewFault(kind int, ip, instruction uint16) *Fault {
+	return &Fault{kind, ip, instruction}
+}
+
+func FaultKindAsString(kind int) string {
+	switch kind {
+	case FaultDivideByZero:
+		return "divide by zero"
+
+	case FaultInvalidOpcode:
+		return "invalid opcode"
+
+	case FaultInvalidRegister:
+		return "invalid register"
+
+	case FaultStackOverflow:
+		return "stack overflow"
+
+	case FaultStackUnderflow:
+		return "stack underflow"
+
+	case FaultSegmentViolation:
+		return "segment violation"
+
+	case FaultIPOutOfBounds:
+		return "ip out of bounds"
+
+	case FaultInvalidInterrupt:
+		return "invalid interrupt"
+
+	default:
+		return "unknown fault"
+	}
+}
+
+func (this *Fault) Error() string {
+	return fmt.Sprintf("fault: %s at ip %d (instruction: %d)", FaultKindAsString(this.kind), this.ip, this.instruction)
+}
+
+func (this *Fault) ExitCode() int {
+	return FaultExitCodeBase + this.kind
+}
+
+/* the fault of the instruction being executed, as an error */
+func (this *CPU) Raise(kind int) error {
+	var instruction uint16
+
+	if int(isa.SegmentTextStart+this.instructionIp) < isa.MemorySize {
+		instruction = this.mainMemory[isa.SegmentTextStart+this.instructionIp]
+	}
+
+	fault := NewFault(kind, this.instructionIp, instruction)
+	this.debugger.Log(fault.Error())
+	return fault
+}
+
+type ExecutionError struct {
+	IP          uint16
+	Instruction string
+	Err         error
+}
+
+func NewExecutionError(ip uint16, instruction string, err error) *ExecutionError {
+	return &ExecutionError{ip, instruction, err}
+}
+
+func (this *ExecutionError) Error() string {
+	var fault *Fault
+
+	if errors.As(this.Err, &fault) {
+		return fmt.Sprintf("fault: %s at ip %d: %s", FaultKindAsString(fault.kind), this.IP, this.Instruction)
+	}
+
+	return fmt.Sprintf("error at ip %d: %s: %v", this.IP, this.Instruction, this.Err)
+}
+
+func (this *ExecutionError) Unwrap() error {
+	return this.Err
+}
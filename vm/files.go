@@ -0,0 +1,294 @@
+package vm
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FileDescriptorFirst = 3
+	FileDescriptorCount = 16
+	FilePathMaximum     = 256
+
+	FileFlagRead      = 0x0000
+	FileFlagWrite     = 0x0001
+	FileFlagReadWrite = 0x0002
+	FileFlagCreate    = 0x0040
+	FileFlagTruncate  = 0x0200
+	FileFlagAppend    = 0x0400
+)
+
+/*
+/
+/ Files:
+/	file syscalls only see the sandbox directory, paths are resolved as if it was the root ("/a.txt", "a.txt" and
+/	"../a.txt" all name the same file) and symbolic links leading out of it, or dangling, are refused,
+/	without a sandbox every file syscall fails with an access error
+/
+/	open:  b path address (nul terminated, one byte per word, at most FilePathMaximum bytes), c flags (linux like, see
+/	       FileFlag*) -> a file descriptor
+/	close: b file descriptor -> a 0
+/	seek:  b file descriptor, c offset (signed), d whence (0 start, 1 current, 2 end) -> a new offset
+/
+/	descriptors start at 3, 0 being stdin, 1 stdout and 2 stderr
+/
+*/
+
+func (this *CPU) SetSandbox(root string) error {
+	if root == "" {
+		this.sandbox = ""
+		return nil
+	}
+
+	root, err := filepath.Abs(root)
+
+	if err != nil {
+		return err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(root); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return errors.New("sandbox is not a directory: " + root)
+	}
+
+	this.sandbox = root
+	return nil
+}
+
+func (this *CPU) InSandbox(real string) bool {
+	return real == this.sandbox || strings.HasPrefix(real, this.sandbox+string(filepath.Separator))
+}
+
+func (this *CPU) ResolvePath(path string) (string, error) {
+	if this.sandbox == "" {
+		return "", fs.ErrPermission
+	}
+
+	resolved := filepath.Join(this.sandbox, filepath.Clean("/"+path))
+
+	/* the file may not exist yet, its closest existing parent is checked instead */
+	existing := resolved
+
+	for {
+		if real, err := filepath.EvalSymlinks(existing); err == nil {
+			if !this.InSandbox(real) {
+				return "", fs.ErrPermission
+			}
+
+			return resolved, nil
+		}
+
+		/* it exists but can't be followed, a dangling link could point anywhere */
+		if _, err := os.Lstat(existing); err == nil {
+			return "", fs.ErrPermission
+		}
+
+		if existing == this.sandbox {
+			return "", fs.ErrNotExist
+		}
+
+		existing = filepath.Dir(existing)
+	}
+}
+
+/* opens a path of the sandbox, checking the opened file is still the one the path resolves to */
+func (this *CPU) OpenPath(path string, flags int) (*os.File, error) {
+	resolved, err := this.ResolvePath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	/* creating with O_EXCL never follows a link put in place of the missing file since it was resolved */
+	if _, err := os.Lstat(resolved); errors.Is(err, fs.ErrNotExist) && flags&os.O_CREATE != 0 {
+		flags |= os.O_EXCL
+	}
+
+	/* and truncating waits for the check, so a file swapped in from outside is never modified */
+	file, err := os.OpenFile(resolved, flags&^os.O_TRUNC, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opened, err := file.Stat()
+
+	if err == nil {
+		var real string
+
+		if real, err = filepath.EvalSymlinks(resolved); err == nil && !this.InSandbox(real) {
+			err = fs.ErrPermission
+		} else if err == nil {
+			var current fs.FileInfo
+
+			if current, err = os.Stat(real); err == nil && !os.SameFile(opened, current) {
+				err = fs.ErrPermission
+			}
+		}
+	}
+
+	if err == nil && flags&os.O_TRUNC != 0 {
+		err = file.Truncate(0)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+var ErrStringTooLong = errors.New("string too long")
+
+/* a nul terminated string of one byte per word, ErrStringTooLong comes with its first FilePathMaximum bytes */
+func (this *CPU) ReadString(address uint16) (string, error) {
+	var builder strings.Builder
+
+	for index := uint16(0); index < FilePathMaximum; index++ {
+		character, err := this.ReadMemory(address + index)
+
+		if err != nil {
+			return "", err
+		} else if character == 0 {
+			return builder.String(), nil
+		}
+
+		builder.WriteByte(byte(character))
+	}
+
+	return builder.String(), ErrStringTooLong
+}
+
+func FileError(err error) uint16 {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return SyscallError(SyscallErrorNotFound)
+
+	case errors.Is(err, fs.ErrPermission):
+		return SyscallError(SyscallErrorAccess)
+
+	case errors.Is(err, fs.ErrExist):
+		return SyscallError(SyscallErrorExists)
+
+	case errors.Is(err, fs.ErrInvalid):
+		return SyscallError(SyscallErrorInvalid)
+
+	default:
+		return SyscallError(SyscallErrorIO)
+	}
+}
+
+func (this *CPU) File(descriptor uint16) *os.File {
+	return this.files[descriptor]
+}
+
+func (this *CPU) SyscallOpen() error {
+	path, err := this.ReadString(this.b)
+
+	/* a cut path could name another file */
+	if errors.Is(err, ErrStringTooLong) {
+		this.a = SyscallError(SyscallErrorNameTooLong)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	descriptor := uint16(FileDescriptorFirst)
+
+	for ; descriptor < FileDescriptorCount && this.files[descriptor] != nil; descriptor++ {
+	}
+
+	if descriptor == FileDescriptorCount {
+		this.a = SyscallError(SyscallErrorTooManyFiles)
+		return nil
+	}
+
+	flags := os.O_RDONLY
+
+	switch this.c & 0x0003 {
+	case FileFlagWrite:
+		flags = os.O_WRONLY
+
+	case FileFlagReadWrite:
+		flags = os.O_RDWR
+	}
+
+	for _, flag := range [][2]int{{FileFlagCreate, os.O_CREATE}, {FileFlagTruncate, os.O_TRUNC}, {FileFlagAppend, os.O_APPEND}} {
+		if int(this.c)&flag[0] != 0 {
+			flags |= flag[1]
+		}
+	}
+
+	file, err := this.OpenPath(path, flags)
+
+	if err != nil {
+		this.a = FileError(err)
+		return nil
+	}
+
+	this.files[descriptor] = file
+	this.a = descriptor
+	return nil
+}
+
+func (this *CPU) SyscallClose() error {
+	file := this.File(this.b)
+
+	if file == nil {
+		this.a = SyscallError(SyscallErrorBadDescriptor)
+		return nil
+	}
+
+	delete(this.files, this.b)
+
+	if err := file.Close(); err != nil {
+		this.a = FileError(err)
+	} else {
+		this.a = 0
+	}
+
+	return nil
+}
+
+func (this *CPU) SyscallSeek() error {
+	file := this.File(this.b)
+
+	if file == nil {
+		this.a = SyscallError(SyscallErrorBadDescriptor)
+		return nil
+	}
+
+	if this.d > io.SeekEnd {
+		this.a = SyscallError(SyscallErrorInvalid)
+		return nil
+	}
+
+	offset, err := file.Seek(int64(int16(this.c)), int(this.d))
+
+	if err != nil {
+		this.a = FileError(err)
+	} else {
+		this.a = uint16(offset)
+	}
+
+	return nil
+}
+
+func (this *CPU) CloseFiles() {
+	for descriptor, file := range this.files {
+		file.Close()
+		delete(this.files, descriptor)
+	}
+}
@@ -0,0 +1,147 @@
+package vm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nfasm/isa"
+)
+
+func NewSandboxCPU(t *testing.T) (*CPU, string, string) {
+	t.Helper()
+
+	root, outside := t.TempDir(), t.TempDir()
+	cpu := NewCPU(false)
+
+	if err := cpu.SetSandbox(root); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return cpu, cpu.sandbox, outside
+}
+
+func TestResolvePathStaysInSandbox(t *testing.T) {
+	cpu, root, _ := NewSandboxCPU(t)
+
+	for _, path := range []string{"a.txt", "/a.txt", "../a.txt", "../../../a.txt", "b/../../a.txt"} {
+		resolved, err := cpu.ResolvePath(path)
+
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		} else if resolved != filepath.Join(root, "a.txt") {
+			t.Fatalf("%q resolved to %q", path, resolved)
+		}
+	}
+}
+
+func TestOpenPathAbsoluteHostPath(t *testing.T) {
+	cpu, _, outside := NewSandboxCPU(t)
+
+	if _, err := cpu.OpenPath(filepath.Join(outside, "secret.txt"), os.O_RDONLY); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want the path to be looked up in the sandbox", err)
+	}
+}
+
+func TestOpenPathSymlinkOut(t *testing.T) {
+	cpu, root, outside := NewSandboxCPU(t)
+
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"link.txt", "dir/secret.txt", "dir/new.txt"} {
+		if _, err := cpu.OpenPath(path, os.O_RDWR|os.O_CREATE); !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("%q: got %v, want a permission error", path, err)
+		}
+	}
+}
+
+func TestOpenPathDanglingSymlink(t *testing.T) {
+	cpu, root, outside := NewSandboxCPU(t)
+	target := filepath.Join(outside, "missing.txt")
+
+	if err := os.Symlink(target, filepath.Join(root, "dangling.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cpu.OpenPath("dangling.txt", os.O_WRONLY|os.O_CREATE); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("got %v, want a permission error", err)
+	}
+
+	if _, err := os.Lstat(target); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("the link target was created: %v", err)
+	}
+}
+
+func TestOpenPathCreateTruncateLink(t *testing.T) {
+	cpu, root, outside := NewSandboxCPU(t)
+
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cpu.OpenPath("link.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("got %v, want a permission error", err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(outside, "secret.txt")); err != nil || string(content) != "secret" {
+		t.Fatalf("the link target was modified: %q, %v", content, err)
+	}
+
+	/* a link inside the sandbox is fine */
+	if err := os.WriteFile(filepath.Join(root, "inside.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("inside.txt", filepath.Join(root, "local.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := cpu.OpenPath("local.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Close()
+
+	if content, err := os.ReadFile(filepath.Join(root, "inside.txt")); err != nil || len(content) != 0 {
+		t.Fatalf("the file was not truncated: %q, %v", content, err)
+	}
+}
+
+func TestSyscallOpenPathTooLong(t *testing.T) {
+	cpu, root, _ := NewSandboxCPU(t)
+	path := "a.txt" + strings.Repeat("/", FilePathMaximum) + "b.txt"
+
+	/* cut at FilePathMaximum the path would name a.txt */
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for index, character := range path + "\x00" {
+		if err := cpu.WriteMemory(isa.SegmentHeapStart+uint16(index), uint16(character)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cpu.b, cpu.c = isa.SegmentHeapStart, FileFlagRead
+
+	if err := cpu.SyscallOpen(); err != nil {
+		t.Fatal(err)
+	} else if cpu.a != SyscallError(SyscallErrorNameTooLong) {
+		t.Fatalf("got %#04x, want %#04x", cpu.a, SyscallError(SyscallErrorNameTooLong))
+	}
+}
@@ -0,0 +1,169 @@
+package vm
+
+import (
+	"errors"
+	"sort"
+
+	"nfasm/isa"
+)
+
+/*
+/
+/ Heap:
+/	the heap spans from SegmentHeapStart up to the break, held in hp, the stack grows down towards it from SegmentStackStart
+/	and the two may never cross: pushing at hp faults with a stack overflow, moving the break up to sp fails
+/
+/	brk:   b new break, 0 to query it -> a the break
+/	alloc: b size in words -> a address of the block
+/	free:  b address of a block given by alloc -> a 0
+/
+/	alloc takes the first gap large enough between the allocated blocks, moving the break when there is none,
+/	blocks are tracked from go, nothing is written into the heap itself
+/
+*/
+
+type HeapBlock struct {
+	address, size uint16
+}
+
+type Heap struct {
+	blocks []HeapBlock
+}
+
+func NewHeap() Heap {
+	return Heap{nil}
+}
+
+/* the end of the last allocated block, the break cannot go below it */
+func (this *Heap) Top() uint16 {
+	if len(this.blocks) == 0 {
+		return isa.SegmentHeapStart
+	}
+
+	last := this.blocks[len(this.blocks)-1]
+	return last.address + last.size
+}
+
+func (this *CPU) Brk(address uint16) (uint16, error) {
+	if address == 0 {
+		return this.hp, nil
+	}
+
+	if address < this.heap.Top() {
+		return 0, errors.New("break below allocated blocks")
+	}
+
+	if address >= this.sp {
+		return 0, errors.New("break collides with the stack")
+	}
+
+	this.hp = address
+	return this.hp, nil
+}
+
+func (this *CPU) Alloc(size uint16) (uint16, error) {
+	if size == 0 {
+		return 0, errors.New("allocation of size 0")
+	}
+
+	address := uint16(isa.SegmentHeapStart)
+	index := 0
+
+	for ; index < len(this.heap.blocks); index++ {
+		block := this.heap.blocks[index]
+
+		if int(block.address)-int(address) >= int(size) {
+			break
+		}
+
+		address = block.address + block.size
+	}
+
+	if int(address)+int(size) > int(this.hp) {
+		if _, err := this.Brk(address + size); err != nil || int(address)+int(size) > 0xffff {
+			return 0, errors.New("out of heap memory")
+		}
+	}
+
+	this.heap.blocks = append(this.heap.blocks, HeapBlock{address, size})
+	sort.Slice(this.heap.blocks, func(i, j int) bool {
+		return this.heap.blocks[i].address < this.heap.blocks[j].address
+	})
+
+	return address, nil
+}
+
+func (this *CPU) Free(address uint16) error {
+	for index, block := range this.heap.blocks {
+		if block.address == address {
+			this.heap.blocks = append(this.heap.blocks[:index], this.heap.blocks[index+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New("free of an address that was not allocated")
+}
+
+func (this *CPU) SyscallBrk() error {
+	if address, err := this.Brk(this.b); err != nil {
+		this.a = SyscallError(SyscallErrorNoMemory)
+	} else {
+		this.a = address
+	}
+
+	return nil
+}
+
+func (this *CPU) SyscallAlloc() error {
+	if address, err := this.Alloc(this.b); err != nil {
+		this.a = SyscallError(SyscallErrorNoMemory)
+	} else {
+		this.a = address
+	}
+
+	return nil
+}
+
+func (this *CPU) SyscallFree() error {
+	if err := this.Free(this.b); err != nil {
+		this.a = SyscallError(SyscallErrorInvalid)
+	} else {
+		this.a = 0
+	}
+
+	return nil
+}
+
+/* the address and size of every allocated block */
+func (this *Heap) Snapshot() []uint16 {
+	var state []uint16
+
+	for _, block := range this.blocks {
+		state = append(state, block.address, block.size)
+	}
+
+	return state
+}
+
+/* a block per two words, there can't be more blocks than words of memory */
+func (this *Heap) Validate(state []uint16) error {
+	if len(state)%2 != 0 || len(state) > 2*isa.MemorySize {
+		return errors.New("failed to restore heap: invalid state size")
+	}
+
+	return nil
+}
+
+func (this *Heap) Restore(state []uint16) error {
+	if err := this.Validate(state); err != nil {
+		return err
+	}
+
+	this.blocks = nil
+
+	for index := 0; index < len(state); index += 2 {
+		this.blocks = append(this.blocks, HeapBlock{state[index], state[index+1]})
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"nfasm/isa"
+)
+
+/*
+/
+/ History:
+/	keeps the records of the last cycles in a ring buffer so they can be undone, the debugger steps back with it
+/	an observer like the others, a cpu without history attached records nothing
+/
+/	undoing a cycle restores the registers it started with, the ram words it overwrote, the timer and the pending
+/	interrupt lines, so stepping forward again takes the same interrupts, writes to other devices, heap bookkeeping,
+/	output and open files are not undone
+/
+*/
+
+const DefaultHistorySize = 10000
+
+type History struct {
+	records      []*StepRecord
+	start, count int
+}
+
+func NewHistory(size int) *History {
+	return &History{make([]*StepRecord, size), 0, 0}
+}
+
+func (this *History) Observe(cpu *CPU, record *StepRecord) {
+	if len(this.records) == 0 {
+		return
+	}
+
+	if this.count == len(this.records) {
+		this.records[this.start] = record
+		this.start = (this.start + 1) % len(this.records)
+		return
+	}
+
+	this.records[(this.start+this.count)%len(this.records)] = record
+	this.count++
+}
+
+func (this *History) Len() int {
+	return this.count
+}
+
+/* the index-th newest record, 0 being the last cycle */
+func (this *History) Recent(index int) *StepRecord {
+	return this.records[(this.start+this.count-1-index)%len(this.records)]
+}
+
+/* undoes the last recorded cycle, returning its record, or nil when the history is exhausted */
+func (this *History) Back(cpu *CPU) *StepRecord {
+	if this.count == 0 {
+		return nil
+	}
+
+	record := this.Recent(0)
+	this.count--
+
+	for index := len(record.Writes) - 1; index >= 0; index-- {
+		if write := record.Writes[index]; int(write.Address) < isa.MemorySize {
+			cpu.mainMemory[write.Address] = write.Previous
+		}
+	}
+
+	for index, register := range cpu.registers {
+		*register = record.Before[index]
+	}
+
+	cpu.timer.Restore(record.Timer)
+	cpu.pendingInterrupts.Store(record.Pending)
+	cpu.steps = record.Step - 1
+	return record
+}
+
+/* the registers right after the index-th newest record */
+func (this *History) After(cpu *CPU, index int) []uint16 {
+	if index == 0 {
+		return cpu.RegisterValues()
+	}
+
+	return this.Recent(index - 1).Before
+}
+
+/* the newest record that changed the register, with the values around it */
+func (this *History) LastRegisterWrite(cpu *CPU, register uint16) (*StepRecord, uint16, uint16) {
+	for index := 0; index < this.count; index++ {
+		record := this.Recent(index)
+
+		if after := this.After(cpu, index); record.Before[register] != after[register] {
+			return record, record.Before[register], after[register]
+		}
+	}
+
+	return nil, 0, 0
+}
+
+/* the newest record that wrote to the address, with the write */
+func (this *History) LastMemoryWrite(address uint16) (*StepRecord, *MemoryWrite) {
+	for index := 0; index < this.count; index++ {
+		record := this.Recent(index)
+
+		for write := len(record.Writes) - 1; write >= 0; write-- {
+			if record.Writes[write].Address == address {
+				return record, &record.Writes[write]
+			}
+		}
+	}
+
+	return nil, nil
+}
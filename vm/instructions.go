@@ -1,12 +1,16 @@
-package main
+package vm
 
-import "fmt"
+import (
+	"io"
+
+	"nfasm/isa"
+)
 
 type InstructionWrapper struct {
     Instruction func(*CPU) error
 }
 
-var Instructions = []InstructionWrapper{{(*CPU).Nop}, {(*CPU).Mov}, {(*CPU).Add}, {(*CPU).Sub}, {(*CPU).Mul}, {(*CPU).Div}, {(*CPU).Rem}, {(*CPU).Or}, {(*CPU).Xor}, {(*CPU).And}, {(*CPU).Not}, {(*CPU).La}, {(*CPU).Las}, {(*CPU).Str}, {(*CPU).Syscall}, {(*CPU).Jmp}, {(*CPU).Jmpl}, {(*CPU).Push}, {(*CPU).Pop}, {(*CPU).Ret}, {(*CPU).Inc}, {(*CPU).Dec}, {(*CPU).Cmp}}
+var Instructions = []InstructionWrapper{{(*CPU).Nop}, {(*CPU).Mov}, {(*CPU).Add}, {(*CPU).Sub}, {(*CPU).Mul}, {(*CPU).Div}, {(*CPU).Rem}, {(*CPU).Or}, {(*CPU).Xor}, {(*CPU).And}, {(*CPU).Not}, {(*CPU).La}, {(*CPU).Las}, {(*CPU).Str}, {(*CPU).Syscall}, {(*CPU).Jmp}, {(*CPU).Jmpl}, {(*CPU).Push}, {(*CPU).Pop}, {(*CPU).Ret}, {(*CPU).Inc}, {(*CPU).Dec}, {(*CPU).Cmp}, {(*CPU).Int}, {(*CPU).Iret}, {(*CPU).Cli}, {(*CPU).Sti}, {(*CPU).Vld}, {(*CPU).Vst}}
 
 /*
 /
@@ -14,7 +18,8 @@ var Instructions = []InstructionWrapper{{(*CPU).Nop}, {(*CPU).Mov}, {(*CPU).Add}
 /	before each function call of the register, syntaxes will be explicit to cover all the cases
 /	all instuctions can be marked with user states, like "mov a, 69, eq", where this instruction would only execute if the user state equal/zero is on
 /	available user states marks that can be used are: [z, nz, c, o]
-/						   or: [eq, ne, gt, lt]
+/						   or: [eq, ne, gt, lt, ge, le]
+/	conditional jumps have pseudo instructions, see pseudo.go
 /
 /	notes:
 /		<destination> can only be a register
@@ -208,6 +213,8 @@ func (this *CPU) Div() error {
 	
 	if err != nil {
 	    return err
+	} else if source == 0 {
+	    return this.Raise(FaultDivideByZero)
 	} else {
 	    *destination /= source
 	}
@@ -241,6 +248,8 @@ func (this *CPU) Rem() error {
 	
 	if err != nil {
 	    return err
+	} else if source == 0 {
+	    return this.Raise(FaultDivideByZero)
 	} else {
 	    *destination %= source
 	}
@@ -401,7 +410,13 @@ func (this *CPU) La() error {
 	if err != nil {
 	    return err
 	} else {
-	    *destination = this.mainMemory[source]
+	    value, err := this.ReadMemory(source)
+
+	    if err != nil {
+		return err
+	    }
+
+	    *destination = value
 	}
     }
 
@@ -427,7 +442,13 @@ func (this *CPU) Las() error {
     if err != nil {
 	return err
     } else {
-	*destination = this.mainMemory[*destination]
+	value, err := this.ReadMemory(*destination)
+
+	if err != nil {
+	    return err
+	}
+
+	*destination = value
     }
 
     return nil
@@ -459,11 +480,9 @@ func (this *CPU) Str() error {
 	if err != nil {
 	    return err
 	} else {
-	    this.mainMemory[*destination] = source
+	    return this.WriteMemory(*destination, source)
 	}
     }
-
-    return nil
 }
 
 /*
@@ -480,22 +499,89 @@ func (this *CPU) Str() error {
 /
 */
 func (this *CPU) Syscall() error {
+    if err := this.CheckSyscall(); err != nil {
+	return err
+    }
+
+    if this.recording != nil && RecordedSyscalls[this.a] {
+	return this.RecordSyscall(this.DispatchSyscall)
+    }
+
+    return this.DispatchSyscall()
+}
+
+/* every syscall goes through here, the numbers are described in syscalls.go */
+func (this *CPU) DispatchSyscall() error {
+    if handler, found := this.syscalls[this.a]; found {
+	return handler(this)
+    }
+
     switch this.a {
     case SyscallReset:
 	break
 
     case SyscallExit:
-	this.rsr ^= ReservedStateRunning
+	this.rsr ^= isa.ReservedStateRunning
 	break
 
-    case SyscallWrite:
-	for i := 0; i < int(this.d); i++ {
-	    if this.b == 1 {
-		fmt.Printf("%c", this.mainMemory[int(this.c) + i])
+    case SyscallRead:
+	var reader io.Reader = this.stdin
+
+	if this.b != 0 {
+	    if file := this.File(this.b); file != nil {
+		reader = file
+	    } else {
+		this.a = SyscallError(SyscallErrorBadDescriptor)
+		break
+	    }
+	}
+
+	if this.b == 0 {
+	    if err := this.FlushOutput(); err != nil {
+		return err
 	    }
 	}
 
+	buffer := make([]byte, this.d)
+	count, err := reader.Read(buffer)
+
+	if err != nil && err != io.EOF {
+	    this.a = FileError(err)
+	    break
+	}
+
+	for i := 0; i < count; i++ {
+	    if err := this.WriteMemory(this.c + uint16(i), uint16(buffer[i])); err != nil {
+		return err
+	    }
+	}
+
+	this.a = uint16(count)
 	break
+
+    case SyscallWrite:
+	return this.SyscallWrite()
+
+    case SyscallOpen:
+	return this.SyscallOpen()
+
+    case SyscallClose:
+	return this.SyscallClose()
+
+    case SyscallSeek:
+	return this.SyscallSeek()
+
+    case SyscallBrk:
+	return this.SyscallBrk()
+
+    case SyscallAlloc:
+	return this.SyscallAlloc()
+
+    case SyscallFree:
+	return this.SyscallFree()
+
+    case SyscallAssert:
+	return this.SyscallAssert()
     }
 
     return nil
@@ -578,11 +664,7 @@ func (this *CPU) Push() error {
 	return err
     }
 
-    this.sp--
-
-    this.mainMemory[this.sp] = source
-
-    return nil
+    return this.PushValue(source)
 }
 
 /*
@@ -605,9 +687,13 @@ func (this *CPU) Pop() error {
 	return err
     }
 
-    *destination = this.mainMemory[this.sp]
-    this.sp++
+    value, err := this.PopValue()
+
+    if err != nil {
+	return err
+    }
 
+    *destination = value
     return nil
 }
 
@@ -686,7 +772,7 @@ func (this *CPU) Dec() error {
 /		cmp destination, source
 /
 /	behavior:
-/		compares destination with source, changing user states
+/		compares destination with source, replacing the zero, carry and overflow user states
 /
 /	examples:
 /		cmp a, b
@@ -706,16 +792,186 @@ func (this *CPU) Cmp() error {
 	    return err
 	} else {
 	    result := int16(*destination - source)
+	    this.usr &^= isa.UserStateConditionMask
 
 	    if result == 0 {
-		this.usr |= UserStateZero
+		this.usr |= isa.UserStateZero
 	    } else if result > 0 {
-		this.usr |= UserStateCarry
+		this.usr |= isa.UserStateCarry
 	    } else if result < 0 {
-		this.usr |= UserStateOverflow
+		this.usr |= isa.UserStateOverflow
 	    }
 	}
     }
 
     return nil
 }
+
+/*
+/
+/ Int:
+/	syntaxes:
+/		int source
+/
+/	behavior:
+/		enters the interrupt handler installed at vector source, see interrupts.go
+/
+/	examples:
+/		int 16
+/		int a
+/
+*/
+func (this *CPU) Int() error {
+    vector, err := this.GetSource()
+
+    if err != nil {
+	return err
+    } else if vector >= isa.InterruptVectorCount || this.InterruptHandler(vector) == 0 {
+	return this.Raise(FaultInvalidInterrupt)
+    } else {
+	return this.EnterInterrupt(this.InterruptHandler(vector))
+    }
+}
+
+/*
+/
+/ Iret:
+/	syntaxes:
+/		iret
+/
+/	behavior:
+/		returns from an interrupt handler, popping the interrupt enable flag, usr then ip
+/
+/	examples:
+/		iret
+/
+*/
+func (this *CPU) Iret() error {
+    enabled, err := this.PopValue()
+
+    if err != nil {
+	return err
+    }
+
+    usr, err := this.PopValue()
+
+    if err != nil {
+	return err
+    }
+
+    ip, err := this.PopValue()
+
+    if err != nil {
+	return err
+    }
+
+    this.usr = usr
+    this.ip = ip
+    this.rsr = this.rsr &^ isa.ReservedStateInterrupts | enabled & isa.ReservedStateInterrupts
+
+    return nil
+}
+
+/*
+/
+/ Cli:
+/	syntaxes:
+/		cli
+/
+/	behavior:
+/		clears the interrupt enable flag of rsr, interrupt request lines are held until sti
+/
+/	examples:
+/		cli
+/
+*/
+func (this *CPU) Cli() error {
+    this.rsr &^= isa.ReservedStateInterrupts
+    return nil
+}
+
+/*
+/
+/ Sti:
+/	syntaxes:
+/		sti
+/
+/	behavior:
+/		sets the interrupt enable flag of rsr
+/
+/	examples:
+/		sti
+/
+*/
+func (this *CPU) Sti() error {
+    this.rsr |= isa.ReservedStateInterrupts
+    return nil
+}
+
+/*
+/
+/ Vld:
+/	syntaxes:
+/		vld destination, source
+/
+/	behavior:
+/		loads the pixel at video memory index source into destination
+/
+/	examples:
+/		vld a, b
+/		vld a, 69
+/
+*/
+func (this *CPU) Vld() error {
+    destination, err := this.GetRegisterReferenceFromEncoding()
+
+    if err != nil {
+	return err
+    } else {
+	source, err := this.GetSource()
+
+	if err != nil {
+	    return err
+	} else if int(source) >= VideoMemorySize {
+	    return this.Raise(FaultSegmentViolation)
+	} else {
+	    *destination = this.videoMemory[source]
+	}
+    }
+
+    return nil
+}
+
+/*
+/
+/ Vst:
+/	syntaxes:
+/		vst destination, source
+/
+/	behavior:
+/		stores source as the pixel at video memory index destination
+/
+/	examples:
+/		vst a, b
+/		vst a, 63488 // red in rgb565
+/
+*/
+func (this *CPU) Vst() error {
+    destination, err := this.GetRegisterReferenceFromEncoding()
+
+    if err != nil {
+	return err
+    } else {
+	source, err := this.GetSource()
+
+	if err != nil {
+	    return err
+	} else if int(*destination) >= VideoMemorySize {
+	    return this.Raise(FaultSegmentViolation)
+	} else {
+	    this.videoMemory[*destination] = source
+	}
+    }
+
+    return nil
+}
@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"errors"
+
+	"nfasm/isa"
+)
+
+const (
+	InterruptVectorFaultBase = 0
+	InterruptVectorLineBase  = 8
+	InterruptLineCount       = 8
+)
+
+/*
+/
+/ Interrupts:
+/	the vector table lives at InterruptVectorStart, each entry is the address of a handler, 0 meaning no handler is installed
+/
+/	vectors:
+/		0-7   faults, indexed by fault kind (divide by zero is vector 0)
+/		8-15  interrupt request lines raised by devices from go, RaiseInterrupt(line)
+/		16-31 free for software interrupts
+/
+/	entering a handler pushes ip, usr, then the interrupt enable flag of rsr (ReservedStateInterrupts or 0) onto the
+/	stack and clears the flag, iret pops the three of them back, so an int executed after cli returns with interrupts
+/	still disabled
+/
+/	lines are only serviced while interrupts are enabled (sti), between two instructions, the lowest line first,
+/	lines without a handler are dropped
+/	faults are delivered whenever a handler is installed for them, the pushed ip being the faulting instruction
+/	software interrupts (int n) ignore the enable flag, calling a vector without a handler faults with an invalid interrupt
+/
+/	installing a handler:
+/		mov a, 1032	// InterruptVectorStart + InterruptVectorLineBase
+/		str a, handler
+/
+*/
+
+func (this *CPU) InterruptHandler(vector uint16) uint16 {
+	return this.mainMemory[isa.InterruptVectorStart+vector]
+}
+
+/* may be called from any goroutine, the line is serviced by the run loop */
+func (this *CPU) RaiseInterrupt(line int) error {
+	if line < 0 || line >= InterruptLineCount {
+		return errors.New("interrupt line out of bounds")
+	}
+
+	for {
+		pending := this.pendingInterrupts.Load()
+
+		if this.pendingInterrupts.CompareAndSwap(pending, pending|1<<line) {
+			return nil
+		}
+	}
+}
+
+func (this *CPU) AcknowledgeInterrupt(line int) {
+	for {
+		pending := this.pendingInterrupts.Load()
+
+		if this.pendingInterrupts.CompareAndSwap(pending, pending&^(1<<line)) {
+			return
+		}
+	}
+}
+
+func (this *CPU) EnterInterrupt(handler uint16) error {
+	if err := this.PushValue(this.ip); err != nil {
+		return err
+	}
+
+	if err := this.PushValue(this.usr); err != nil {
+		return err
+	}
+
+	if err := this.PushValue(this.rsr & isa.ReservedStateInterrupts); err != nil {
+		return err
+	}
+
+	this.rsr &^= isa.ReservedStateInterrupts
+	this.ip = handler
+	this.debugger.Log("entered interrupt handler:", handler)
+	return nil
+}
+
+func (this *CPU) ServiceInterrupts() error {
+	if this.rsr&isa.ReservedStateInterrupts == 0x0000 {
+		return nil
+	}
+
+	pending := this.pendingInterrupts.Load()
+
+	for line := 0; line < InterruptLineCount; line++ {
+		if pending&(1<<line) == 0 {
+			continue
+		}
+
+		this.AcknowledgeInterrupt(line)
+		handler := this.InterruptHandler(uint16(InterruptVectorLineBase + line))
+
+		if handler != 0 {
+			return this.EnterInterrupt(handler)
+		}
+	}
+
+	return nil
+}
+
+/* delivers the fault in err to its handler, err is returned untouched when it is not a fault or no handler is installed */
+func (this *CPU) ServiceFault(err error) error {
+	var fault *Fault
+
+	if !errors.As(err, &fault) {
+		return err
+	}
+
+	handler := this.InterruptHandler(uint16(InterruptVectorFaultBase + fault.kind))
+
+	if handler == 0 {
+		return err
+	}
+
+	this.ip = fault.ip
+
+	if this.EnterInterrupt(handler) != nil {
+		return err
+	}
+
+	return nil
+}
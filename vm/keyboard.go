@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	KeyboardAddress = 0xff10
+
+	KeyboardRegisterStatus  = 0
+	KeyboardRegisterData    = 1
+	KeyboardRegisterControl = 2
+	KeyboardRegisterCount   = 3
+
+	KeyboardStatusAvailable  = 0x0001
+	KeyboardControlInterrupt = 0x0001
+
+	KeyboardInterruptLine = 1
+
+	KeyboardQueueMaximum = 4096 // keys fed while the queue holds this many are dropped
+)
+
+/*
+/
+/ Keyboard:
+/	a queue of key bytes fed from go (Feed) or from a host reader (Connect)
+/
+/	registers (memory mapped from KeyboardAddress):
+/		+0 status:  [available], polling it never blocks
+/		+1 data:    reading it pops the next key, 0 when the queue is empty
+/		+2 control: [interrupt], raise interrupt line KeyboardInterruptLine whenever keys are fed
+/
+*/
+
+type Keyboard struct {
+	mutex   sync.Mutex
+	queue   []byte
+	control uint16
+	raise   func(int) error
+}
+
+func NewKeyboard(raise func(int) error) *Keyboard {
+	return &Keyboard{sync.Mutex{}, nil, 0, raise}
+}
+
+/* what was fed depends on the timing of the host, see record.go */
+func (this *Keyboard) Volatile() bool {
+	return true
+}
+
+/* safe to call from any goroutine */
+func (this *Keyboard) Feed(keys []byte) {
+	this.mutex.Lock()
+	keys = keys[:min(len(keys), max(KeyboardQueueMaximum-len(this.queue), 0))]
+	this.queue = append(this.queue, keys...)
+	interrupt := this.control&KeyboardControlInterrupt != 0x0000
+	this.mutex.Unlock()
+
+	if interrupt && len(keys) != 0 {
+		this.raise(KeyboardInterruptLine)
+	}
+}
+
+/* feeds everything read from reader in the background, until it fails or reaches its end */
+func (this *Keyboard) Connect(reader io.Reader) {
+	go func() {
+		buffer := make([]byte, 64)
+
+		for {
+			count, err := reader.Read(buffer)
+			this.Feed(buffer[:count])
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (this *Keyboard) Read(register uint16) (uint16, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	switch register {
+	case KeyboardRegisterStatus:
+		if len(this.queue) != 0 {
+			return KeyboardStatusAvailable, nil
+		}
+
+		return 0, nil
+
+	case KeyboardRegisterData:
+		if len(this.queue) == 0 {
+			return 0, nil
+		}
+
+		key := this.queue[0]
+		this.queue = this.queue[1:]
+		return uint16(key), nil
+
+	case KeyboardRegisterControl:
+		return this.control, nil
+
+	default:
+		return 0, errors.New("keyboard register out of bounds")
+	}
+}
+
+func (this *Keyboard) Write(register, value uint16) error {
+	this.mutex.Lock()
+
+	switch register {
+	case KeyboardRegisterControl:
+		this.control = value
+		interrupt := value&KeyboardControlInterrupt != 0x0000 && len(this.queue) != 0
+		this.mutex.Unlock()
+
+		if interrupt {
+			this.raise(KeyboardInterruptLine)
+		}
+
+		return nil
+
+	default:
+		this.mutex.Unlock()
+		return errors.New("keyboard register is read only")
+	}
+}
+
+/* the control register followed by the queued keys */
+func (this *Keyboard) Snapshot() []uint16 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	state := []uint16{this.control}
+
+	for _, key := range this.queue {
+		state = append(state, uint16(key))
+	}
+
+	return state
+}
+
+/* the control register followed by the queued keys */
+func (this *Keyboard) Validate(state []uint16) error {
+	if len(state) == 0 || len(state) > 1+KeyboardQueueMaximum {
+		return errors.New("failed to restore keyboard: invalid state size")
+	}
+
+	return nil
+}
+
+func (this *Keyboard) Restore(state []uint16) error {
+	if err := this.Validate(state); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.control, this.queue = state[0], nil
+
+	for _, key := range state[1:] {
+		this.queue = append(this.queue, byte(key))
+	}
+
+	return nil
+}
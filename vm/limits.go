@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+/
+/ Limits:
+/	bound what an untrusted program can do, the run loop stops it with one of the errors below as soon as it goes over
+/		maxSteps   instructions executed, ErrStepLimit
+/		timeout    wall-clock time of Run (on top of the deadline of its context), ErrTimeLimit
+/		maxOutput  bytes written by the write syscall to any descriptor, rendered by the console and written as gpu
+/		           frames, ErrOutputLimit
+/		syscalls   the syscall numbers allowed, nil for all of them, ErrSyscallDenied
+/	zero values mean no limit
+/
+/	the clock is checked between instructions, a syscall blocked on its input (a terminal, a pipe) holds it up,
+/	give untrusted programs their stdin from a file
+/
+*/
+
+type Limits struct {
+	MaxSteps  uint64
+	Timeout   time.Duration
+	MaxOutput uint64
+	Syscalls  map[uint16]bool
+}
+
+/* the context is checked every this many steps, checking it on every step slows the run loop down */
+const LimitsContextInterval = 1024
+
+var (
+	ErrStepLimit     = errors.New("step limit reached")
+	ErrTimeLimit     = errors.New("time limit reached")
+	ErrOutputLimit   = errors.New("output limit reached")
+	ErrSyscallDenied = errors.New("syscall not allowed")
+)
+
+var SyscallNames = map[string]uint16{
+	"reset": SyscallReset, "exit": SyscallExit, "read": SyscallRead, "write": SyscallWrite, "open": SyscallOpen,
+	"close": SyscallClose, "seek": SyscallSeek, "brk": SyscallBrk, "alloc": SyscallAlloc, "free": SyscallFree,
+	"assert": SyscallAssert,
+}
+
+/* a comma separated list of syscall names or numbers, an empty list allows every syscall */
+func ParseSyscalls(list string) (map[uint16]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	syscalls := make(map[uint16]bool)
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+
+		if number, found := SyscallNames[name]; found {
+			syscalls[number] = true
+		} else if number, err := strconv.ParseUint(name, 0, 16); err == nil {
+			syscalls[uint16(number)] = true
+		} else {
+			return nil, errors.New("unknown syscall: " + name)
+		}
+	}
+
+	return syscalls, nil
+}
+
+func (this *CPU) SetLimits(limits Limits) {
+	this.limits = limits
+}
+
+func (this *CPU) CheckSyscall() error {
+	if this.limits.Syscalls != nil && !this.limits.Syscalls[this.a] {
+		return fmt.Errorf("%w: %d", ErrSyscallDenied, this.a)
+	}
+
+	return nil
+}
+
+/* counts the bytes about to be written, failing when they don't fit in the limit */
+func (this *CPU) CheckOutput(count uint64) error {
+	if this.limits.MaxOutput != 0 && this.written+count > this.limits.MaxOutput {
+		return fmt.Errorf("%w: %d bytes", ErrOutputLimit, this.limits.MaxOutput)
+	}
+
+	this.written += count
+	return nil
+}
+
+func (this *CPU) CheckContext(ctx context.Context) error {
+	if this.steps%LimitsContextInterval != 0 {
+		return nil
+	}
+
+	if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeLimit
+	} else {
+		return err
+	}
+}
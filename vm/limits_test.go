@@ -0,0 +1,123 @@
+package vm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"nfasm/asm"
+	"nfasm/vm"
+)
+
+const (
+	LimitsLoop = "loop:\njmp loop\n"
+	LimitsExit = "mov a, 1\nmov b, 0\nsyscall\n"
+)
+
+func RunLimited(t *testing.T, ctx context.Context, source string, limits vm.Limits, setup func(cpu *vm.CPU)) (*vm.CPU, error) {
+	t.Helper()
+
+	program, err := asm.Assemble(source)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu, err := vm.New(vm.Options{Stdin: strings.NewReader(""), Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}, Limits: limits})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.LoadProgramFromMemory(program.Words()); err != nil {
+		t.Fatal(err)
+	}
+
+	if setup != nil {
+		setup(cpu)
+	}
+
+	return cpu, cpu.Run(ctx, nil)
+}
+
+func TestStepLimit(t *testing.T) {
+	cpu, err := RunLimited(t, context.Background(), LimitsLoop, vm.Limits{MaxSteps: 1000}, nil)
+
+	if !errors.Is(err, vm.ErrStepLimit) {
+		t.Fatalf("got %v, want %v", err, vm.ErrStepLimit)
+	} else if cpu.Steps() != 1000 {
+		t.Fatalf("ran %d steps, want 1000", cpu.Steps())
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	if _, err := RunLimited(t, context.Background(), LimitsLoop, vm.Limits{Timeout: 10 * time.Millisecond}, nil); !errors.Is(err, vm.ErrTimeLimit) {
+		t.Fatalf("got %v, want %v", err, vm.ErrTimeLimit)
+	}
+}
+
+func TestCancelledIsNotTimeLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := RunLimited(t, ctx, LimitsLoop, vm.Limits{Timeout: time.Minute}, nil)
+
+	if !errors.Is(err, context.Canceled) || errors.Is(err, vm.ErrTimeLimit) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOutputLimitWrite(t *testing.T) {
+	source := "mov a, 4\nmov b, 1\nmov c, msg\nmov d, 6\nsyscall\nmov a, 4\nsyscall\n" + LimitsExit + "msg:\ndb \"hello\\n\"\n"
+
+	if _, err := RunLimited(t, context.Background(), source, vm.Limits{MaxOutput: 6}, nil); !errors.Is(err, vm.ErrOutputLimit) {
+		t.Fatalf("got %v, want %v", err, vm.ErrOutputLimit)
+	}
+
+	if _, err := RunLimited(t, context.Background(), source, vm.Limits{MaxOutput: 12}, nil); err != nil {
+		t.Fatalf("got %v within the limit", err)
+	}
+}
+
+func TestOutputLimitConsole(t *testing.T) {
+	source := "mov a, 6098\nstr a, 2\n" + LimitsExit
+
+	_, err := RunLimited(t, context.Background(), source, vm.Limits{MaxOutput: 1000}, func(cpu *vm.CPU) {
+		if err := cpu.Console().SetOutput(&bytes.Buffer{}, vm.ConsoleModeANSI); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if !errors.Is(err, vm.ErrOutputLimit) {
+		t.Fatalf("got %v, want %v", err, vm.ErrOutputLimit)
+	}
+}
+
+func TestOutputLimitGPU(t *testing.T) {
+	source := "mov a, 65288\nstr a, 1\n" + LimitsExit
+
+	_, err := RunLimited(t, context.Background(), source, vm.Limits{MaxOutput: 1000}, func(cpu *vm.CPU) {
+		if err := cpu.GPU().SetOutput(t.TempDir(), "ppm", 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if !errors.Is(err, vm.ErrOutputLimit) {
+		t.Fatalf("got %v, want %v", err, vm.ErrOutputLimit)
+	}
+}
+
+func TestSyscallDenied(t *testing.T) {
+	source := "mov a, 4\nmov b, 1\nmov c, 0\nmov d, 1\nsyscall\n" + LimitsExit
+
+	if _, err := RunLimited(t, context.Background(), source, vm.Limits{Syscalls: map[uint16]bool{vm.SyscallExit: true}}, nil); !errors.Is(err, vm.ErrSyscallDenied) {
+		t.Fatalf("got %v, want %v", err, vm.ErrSyscallDenied)
+	}
+
+	if _, err := RunLimited(t, context.Background(), LimitsExit, vm.Limits{Syscalls: map[uint16]bool{vm.SyscallExit: true}}, nil); err != nil {
+		t.Fatalf("got %v for an allowed syscall", err)
+	}
+}
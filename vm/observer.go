@@ -0,0 +1,75 @@
+package vm
+
+type MemoryWrite struct {
+	Address, Previous, Value uint16
+}
+
+/*
+/
+/ Observers:
+/	attached observers are handed a record of every cycle once it is over, records are only built while observers are
+/	attached, so an unobserved cpu pays nothing for them
+/
+/	a record holds the instruction address, whether it is conditional, whether it executed, the registers before the
+/	cycle, the words it wrote on the bus (the previous value is only known for ram) and the error that stopped it, if any,
+/	the timer registers and the pending interrupt lines before the cycle are kept too, so History can undo them
+/	interrupt entries and fault deliveries are part of the cycle they happen in
+/
+*/
+
+type StepRecord struct {
+	Step        uint64
+	IP          uint16
+	Opcode      uint16
+	Executed    bool
+	Conditional bool
+	Before      []uint16
+	Writes      []MemoryWrite
+	Err         error
+	Timer       []uint16
+	Pending     uint32
+}
+
+type Observer interface {
+	Observe(cpu *CPU, record *StepRecord)
+}
+
+func (this *CPU) AddObserver(observer Observer) {
+	this.observers = append(this.observers, observer)
+}
+
+func (this *CPU) BeginRecord() {
+	if len(this.observers) == 0 {
+		return
+	}
+
+	this.record = &StepRecord{this.steps + 1, this.ip, 0, false, false, this.RegisterValues(), nil, nil, this.timer.Snapshot(), this.pendingInterrupts.Load()}
+}
+
+func (this *CPU) EndRecord(err error) {
+	if this.record == nil {
+		return
+	}
+
+	record := this.record
+	record.Err = err
+	this.record = nil
+
+	for _, observer := range this.observers {
+		observer.Observe(this, record)
+	}
+}
+
+func (this *CPU) RecordWrite(mapping *BusMapping, address, value uint16) {
+	if this.record == nil {
+		return
+	}
+
+	var previous uint16
+
+	if ram, ok := mapping.device.(*Ram); ok {
+		previous, _ = ram.Read(address - mapping.start)
+	}
+
+	this.record.Writes = append(this.record.Writes, MemoryWrite{address, previous, value})
+}
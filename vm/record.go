@@ -0,0 +1,362 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+/
+/ Record and replay:
+/	"exe --record file" logs every input the program could see differently on another run, "exe --replay file" feeds
+/	them back in the same order so the run is the same, whatever the terminal, the files or the timing:
+/		the results of RecordedSyscalls, and the words read was given
+/		the reads of devices implementing VolatileDevice (the keyboard)
+/		the interrupts raised from outside the run loop (RaiseExternalInterrupt), by the step they were serviced at
+/
+/	the log is plain text, after a "nfasm-record <version>" line:
+/		syscall <step> <number> <b> <c> <d> <result> [<word>,<word>...]
+/		read <step> <address> <value>
+/		irq <step> <line>
+/
+/	while replaying, recorded syscalls are not performed (writes to stdout and stderr still are, to be seen) and the
+/	run stops with a ReplayError as soon as the program asks for something else than what was recorded next, a syscall
+/	has to come with the same arguments (descriptor, buffer, length) as well
+/
+*/
+
+const RecordVersion = 2
+
+var RecordedSyscalls = map[uint16]bool{SyscallRead: true, SyscallWrite: true, SyscallOpen: true, SyscallClose: true, SyscallSeek: true}
+
+/* devices whose reads depend on more than what the program did */
+type VolatileDevice interface {
+	Volatile() bool
+}
+
+type RecordEvent struct {
+	kind      string
+	step      uint64
+	number    uint16
+	arguments []uint16 // b, c and d of a syscall
+	value     uint16
+	data      []uint16
+}
+
+/* the part of the event the program decides, compared when replaying */
+func (this *RecordEvent) Request() string {
+	text := fmt.Sprintf("%s %d", this.kind, this.number)
+
+	for _, argument := range this.arguments {
+		text += fmt.Sprintf(" %d", argument)
+	}
+
+	return text
+}
+
+func (this *RecordEvent) String() string {
+	text := fmt.Sprintf("%s %d %d", this.kind, this.step, this.number)
+
+	for _, argument := range this.arguments {
+		text += fmt.Sprintf(" %d", argument)
+	}
+
+	if this.kind != "irq" {
+		text += fmt.Sprintf(" %d", this.value)
+	}
+
+	if len(this.data) != 0 {
+		words := make([]string, len(this.data))
+
+		for index, word := range this.data {
+			words[index] = strconv.Itoa(int(word))
+		}
+
+		text += " " + strings.Join(words, ",")
+	}
+
+	return text
+}
+
+type ReplayError struct {
+	step     uint64
+	expected string
+	actual   string
+}
+
+func (this *ReplayError) Error() string {
+	return fmt.Sprintf("replay diverged at step %d: recorded %s, program did %s", this.step, this.expected, this.actual)
+}
+
+type Recording struct {
+	replaying bool
+	writer    *bufio.Writer
+	events    []RecordEvent
+	next      int
+	external  atomic.Uint32 // lines raised from outside since the last cycle, while recording
+	stored    *[]uint16     // the words written by the syscall being recorded, nil outside of one
+}
+
+func NewRecorder(writer io.Writer) *Recording {
+	recording := &Recording{false, bufio.NewWriter(writer), nil, 0, atomic.Uint32{}, nil}
+	fmt.Fprintf(recording.writer, "nfasm-record %d\n", RecordVersion)
+	return recording
+}
+
+func ReadRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	recording := &Recording{true, nil, nil, 0, atomic.Uint32{}, nil}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+
+	if !scanner.Scan() || scanner.Text() != fmt.Sprintf("nfasm-record %d", RecordVersion) {
+		return nil, fmt.Errorf("%s: not a version %d recording", path, RecordVersion)
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		invalid := fmt.Errorf("%s: invalid recording line: %s", path, scanner.Text())
+
+		/* the numbers following the kind, the step first */
+		count := map[string]int{"irq": 2, "read": 3, "syscall": 6}[fields[0]]
+
+		if count == 0 || len(fields) != count+1 && (fields[0] != "syscall" || len(fields) != count+2) {
+			return nil, invalid
+		}
+
+		event := RecordEvent{fields[0], 0, 0, nil, 0, nil}
+		var numbers []uint64
+
+		for _, field := range fields[1 : count+1] {
+			number, err := strconv.ParseUint(field, 10, 64)
+
+			if err != nil {
+				return nil, invalid
+			}
+
+			numbers = append(numbers, number)
+		}
+
+		switch event.kind {
+		case "syscall":
+			for _, argument := range numbers[2:5] {
+				event.arguments = append(event.arguments, uint16(argument))
+			}
+
+			if len(fields) == count+2 {
+				for _, word := range strings.Split(fields[count+1], ",") {
+					value, err := strconv.ParseUint(word, 10, 16)
+
+					if err != nil {
+						return nil, invalid
+					}
+
+					event.data = append(event.data, uint16(value))
+				}
+			}
+		}
+
+		event.step, event.number = numbers[0], uint16(numbers[1])
+
+		if count > 2 {
+			event.value = uint16(numbers[count-1])
+		}
+
+		recording.events = append(recording.events, event)
+	}
+
+	return recording, scanner.Err()
+}
+
+func (this *Recording) Log(event RecordEvent) {
+	fmt.Fprintln(this.writer, event.String())
+}
+
+/* the next recorded event, which has to be of kind and carry number and arguments, at step */
+func (this *Recording) Expect(kind string, step uint64, number uint16, arguments ...uint16) (*RecordEvent, error) {
+	request := RecordEvent{kind, step, number, arguments, 0, nil}
+
+	if this.next == len(this.events) {
+		return nil, &ReplayError{step, "nothing more", request.Request()}
+	}
+
+	event := &this.events[this.next]
+
+	if event.step != step || event.Request() != request.Request() {
+		return nil, &ReplayError{step, fmt.Sprintf("%s at step %d", event.Request(), event.step), request.Request()}
+	}
+
+	this.next++
+	return event, nil
+}
+
+/* flushes a recording, or checks that a replay used every event */
+func (this *Recording) Finish(steps uint64) error {
+	if !this.replaying {
+		return this.writer.Flush()
+	}
+
+	if this.next != len(this.events) {
+		event := &this.events[this.next]
+		return &ReplayError{steps, fmt.Sprintf("%s at step %d", event.Request(), event.step), "stop"}
+	}
+
+	return nil
+}
+
+/* records the run into a NewRecorder, or replays a ReadRecording, nil turns both off */
+func (this *CPU) SetRecording(recording *Recording) {
+	this.recording = recording
+}
+
+/* finishes the recording of the cpu once its program stopped, nothing to do without one */
+func (this *CPU) FinishRecording() error {
+	if this.recording == nil {
+		return nil
+	}
+
+	return this.recording.Finish(this.steps)
+}
+
+/* where asynchronous sources (the keyboard) raise their lines, so recordings see them at a step boundary */
+func (this *CPU) RaiseExternalInterrupt(line int) error {
+	if this.recording == nil {
+		return this.RaiseInterrupt(line)
+	} else if this.recording.replaying {
+		return nil
+	}
+
+	for {
+		pending := this.recording.external.Load()
+
+		if this.recording.external.CompareAndSwap(pending, pending|1<<line) {
+			return nil
+		}
+	}
+}
+
+/* raises the external lines of the coming step, the recorded ones when replaying */
+func (this *CPU) ExternalInterrupts() error {
+	if this.recording == nil {
+		return nil
+	}
+
+	step := this.steps + 1
+
+	if !this.recording.replaying {
+		pending := this.recording.external.Swap(0)
+
+		for line := 0; line < InterruptLineCount; line++ {
+			if pending&(1<<line) != 0 {
+				this.recording.Log(RecordEvent{"irq", step, uint16(line), nil, 0, nil})
+				this.RaiseInterrupt(line)
+			}
+		}
+
+		return nil
+	}
+
+	for this.recording.next < len(this.recording.events) {
+		event := &this.recording.events[this.recording.next]
+
+		if event.kind != "irq" || event.step > step {
+			return nil
+		} else if event.step < step {
+			return &ReplayError{step, fmt.Sprintf("irq %d at step %d", event.number, event.step), "no interrupt"}
+		}
+
+		this.recording.next++
+
+		if err := this.RaiseInterrupt(int(event.number)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* reads a volatile device through the recording, the device itself isn't touched while replaying */
+func (this *CPU) ReadVolatile(mapping *BusMapping, address uint16) (uint16, error) {
+	if this.recording.replaying {
+		event, err := this.recording.Expect("read", this.steps, address)
+
+		if err != nil {
+			return 0, err
+		}
+
+		return event.value, nil
+	}
+
+	value, err := mapping.device.Read(address - mapping.start)
+
+	if err == nil {
+		this.recording.Log(RecordEvent{"read", this.steps, address, nil, value, nil})
+	}
+
+	return value, err
+}
+
+/* called by WriteMemory, keeps the words stored while a read is recorded */
+func (this *CPU) RecordStore(value uint16) {
+	if this.recording != nil && this.recording.stored != nil {
+		*this.recording.stored = append(*this.recording.stored, value)
+	}
+}
+
+/* performs or replays the syscall in a, see RecordedSyscalls */
+func (this *CPU) RecordSyscall(dispatch func() error) error {
+	number, descriptor, buffer := this.a, this.b&^SyscallFlagPacked, this.c
+	arguments := []uint16{this.b, this.c, this.d}
+
+	if !this.recording.replaying {
+		var stored []uint16
+
+		/* the words a read stored are kept as it wrote them, reading them back could go through a device */
+		if number == SyscallRead {
+			this.recording.stored = &stored
+		}
+
+		err := dispatch()
+		this.recording.stored = nil
+
+		if err != nil {
+			return err
+		}
+
+		this.recording.Log(RecordEvent{"syscall", this.steps, number, arguments, this.a, stored})
+		return nil
+	}
+
+	event, err := this.recording.Expect("syscall", this.steps, number, arguments...)
+
+	if err != nil {
+		return err
+	}
+
+	if number == SyscallWrite && (descriptor == 1 || descriptor == 2) {
+		if err := dispatch(); err != nil {
+			return err
+		}
+	}
+
+	for index, word := range event.data {
+		if err := this.WriteMemory(buffer+uint16(index), word); err != nil {
+			return err
+		}
+	}
+
+	this.a = event.value
+	return nil
+}
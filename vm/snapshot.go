@@ -0,0 +1,236 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"nfasm/isa"
+)
+
+/*
+/
+/ Snapshots:
+/	the whole machine state between two cycles, written by "exe --save-on-exit file" and resumed by "exe --restore file"
+/
+/	the file starts with SnapshotMagic and SnapshotVersion, then holds one section per part of the machine:
+/		<tag: uint16> <length in words: uint32> <words: uint16...>
+/	all of it little endian, every tag of the version must be present exactly once
+/
+/	open files and the configured outputs are not part of it, they belong to the run and not the machine, a restored
+/	program sees its file descriptors closed, the steps taken and the bytes written are kept so the limits carry on
+/
+/	every section is checked against SnapshotSizes before it is read, and all of them are validated before anything
+/	is restored, a snapshot that fails leaves the cpu as it was
+/
+/	a machine halted by a failed instruction is saved with ip on that instruction, restoring it runs the instruction again
+/
+*/
+
+const (
+	SnapshotMagic   = "NFSN"
+	SnapshotVersion = 3
+)
+
+const (
+	SnapshotRegisters = iota
+	SnapshotProgramSize
+	SnapshotMainMemory
+	SnapshotVideoMemory
+	SnapshotInterrupts
+	SnapshotHeap
+	SnapshotTimer
+	SnapshotConsole
+	SnapshotKeyboard
+	SnapshotGPU
+	SnapshotCounters
+	SnapshotSectionCount
+)
+
+/* the largest length of every section, in words */
+var SnapshotSizes = [SnapshotSectionCount]uint32{
+	SnapshotRegisters:   isa.RegisterEncodingCount,
+	SnapshotProgramSize: 1,
+	SnapshotMainMemory:  isa.MemorySize,
+	SnapshotVideoMemory: VideoMemorySize,
+	SnapshotInterrupts:  2,
+	SnapshotHeap:        2 * isa.MemorySize,
+	SnapshotTimer:       TimerRegisterCount,
+	SnapshotConsole:     ConsoleSize,
+	SnapshotKeyboard:    1 + KeyboardQueueMaximum,
+	SnapshotGPU:         4,
+	SnapshotCounters:    8,
+}
+
+/* steps then written, four words each, the low ones first */
+func SnapshotCounterWords(counters ...uint64) []uint16 {
+	var words []uint16
+
+	for _, counter := range counters {
+		words = append(words, uint16(counter), uint16(counter>>16), uint16(counter>>32), uint16(counter>>48))
+	}
+
+	return words
+}
+
+func SnapshotCounter(words []uint16) uint64 {
+	return uint64(words[0]) | uint64(words[1])<<16 | uint64(words[2])<<32 | uint64(words[3])<<48
+}
+
+func (this *CPU) SnapshotSections() [][]uint16 {
+	this.LoadRegisters()
+	pending := this.pendingInterrupts.Load()
+
+	return [][]uint16{
+		SnapshotRegisters:   this.RegisterValues(),
+		SnapshotProgramSize: {this.programSize},
+		SnapshotMainMemory:  this.mainMemory,
+		SnapshotVideoMemory: this.videoMemory,
+		SnapshotInterrupts:  {uint16(pending), uint16(pending >> 16)},
+		SnapshotHeap:        this.heap.Snapshot(),
+		SnapshotTimer:       this.timer.Snapshot(),
+		SnapshotConsole:     this.console.Snapshot(),
+		SnapshotKeyboard:    this.keyboard.Snapshot(),
+		SnapshotGPU:         this.gpu.Snapshot(),
+		SnapshotCounters:    SnapshotCounterWords(this.steps, this.written),
+	}
+}
+
+func (this *CPU) Snapshot(writer io.Writer) error {
+	buffered := bufio.NewWriter(writer)
+	buffered.WriteString(SnapshotMagic)
+	binary.Write(buffered, binary.LittleEndian, uint16(SnapshotVersion))
+
+	for tag, words := range this.SnapshotSections() {
+		binary.Write(buffered, binary.LittleEndian, uint16(tag))
+		binary.Write(buffered, binary.LittleEndian, uint32(len(words)))
+
+		if err := binary.Write(buffered, binary.LittleEndian, words); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
+
+/* replaces the whole state of the cpu, it can then Resume where the snapshot was taken */
+func (this *CPU) Restore(reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	header := make([]byte, len(SnapshotMagic)+2)
+
+	if _, err := io.ReadFull(buffered, header); err != nil || string(header[:len(SnapshotMagic)]) != SnapshotMagic {
+		return errors.New("failed to restore snapshot: not a snapshot")
+	}
+
+	if version := binary.LittleEndian.Uint16(header[len(SnapshotMagic):]); version != SnapshotVersion {
+		return fmt.Errorf("failed to restore snapshot: unsupported version %d, expected %d", version, SnapshotVersion)
+	}
+
+	sections := make([][]uint16, SnapshotSectionCount)
+
+	for {
+		var tag uint16
+		var length uint32
+
+		if err := binary.Read(buffered, binary.LittleEndian, &tag); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if err := binary.Read(buffered, binary.LittleEndian, &length); err != nil {
+			return err
+		}
+
+		if tag >= SnapshotSectionCount || sections[tag] != nil {
+			return fmt.Errorf("failed to restore snapshot: unexpected section %d", tag)
+		} else if length > SnapshotSizes[tag] {
+			return fmt.Errorf("failed to restore snapshot: section %d is too large", tag)
+		}
+
+		sections[tag] = make([]uint16, length)
+
+		if err := binary.Read(buffered, binary.LittleEndian, sections[tag]); err != nil {
+			return err
+		}
+	}
+
+	for tag, words := range sections {
+		if words == nil {
+			return fmt.Errorf("failed to restore snapshot: missing section %d", tag)
+		}
+	}
+
+	for _, tag := range []int{SnapshotRegisters, SnapshotProgramSize, SnapshotMainMemory, SnapshotVideoMemory, SnapshotInterrupts, SnapshotCounters} {
+		if len(sections[tag]) != int(SnapshotSizes[tag]) {
+			return errors.New("failed to restore snapshot: invalid section size")
+		}
+	}
+
+	devices := []struct {
+		validate, restore func([]uint16) error
+		tag               int
+	}{
+		{this.heap.Validate, this.heap.Restore, SnapshotHeap},
+		{this.timer.Validate, this.timer.Restore, SnapshotTimer},
+		{this.console.Validate, this.console.Restore, SnapshotConsole},
+		{this.keyboard.Validate, this.keyboard.Restore, SnapshotKeyboard},
+		{this.gpu.Validate, this.gpu.Restore, SnapshotGPU},
+	}
+
+	for _, device := range devices {
+		if err := device.validate(sections[device.tag]); err != nil {
+			return err
+		}
+	}
+
+	this.LoadRegisters()
+
+	for index, register := range this.registers {
+		*register = sections[SnapshotRegisters][index]
+	}
+
+	this.programSize = sections[SnapshotProgramSize][0]
+	copy(this.mainMemory, sections[SnapshotMainMemory])
+	copy(this.videoMemory, sections[SnapshotVideoMemory])
+	this.pendingInterrupts.Store(uint32(sections[SnapshotInterrupts][0]) | uint32(sections[SnapshotInterrupts][1])<<16)
+	this.steps = SnapshotCounter(sections[SnapshotCounters][0:4])
+	this.written = SnapshotCounter(sections[SnapshotCounters][4:8])
+
+	/* validated above, restoring can't fail anymore */
+	for _, device := range devices {
+		device.restore(sections[device.tag])
+	}
+
+	this.CloseFiles()
+	return nil
+}
+
+func (this *CPU) SaveSnapshot(path string) error {
+	file, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := this.Snapshot(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (this *CPU) RestoreSnapshot(path string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	return this.Restore(file)
+}
@@ -0,0 +1,161 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+    SyscallReset = 0
+    SyscallExit = 1
+    SyscallRead = 3
+    SyscallWrite = 4
+    SyscallOpen = 5
+    SyscallClose = 6
+    SyscallSeek = 19
+    SyscallBrk = 45
+    SyscallAlloc = 90
+    SyscallFree = 91
+    SyscallAssert = 100		// nfasm's own, for "nfasm test"
+
+    SyscallFlagPacked = 0x8000	// or'ed into the file descriptor of write
+)
+
+/*
+/
+/ Syscalls:
+/	the syscall number goes in a, arguments in b, c, d, results come back in a
+/	errors are returned as negative codes (two's complement) in a, the numbers follow linux (SyscallError*)
+/
+/	reset: nothing
+/	exit:  b exit code
+/	read:  b file descriptor (0 for stdin), c buffer address, d maximum length -> a count read (0 at the end of input)
+/	write: b file descriptor (1 stdout, 2 stderr), c buffer address, d length in bytes -> a count written
+/	open, close and seek are described in files.go
+/	brk, alloc and free are described in heap.go
+/	assert: b actual value, c expected value, d address of a nul terminated message or 0 -> a 0, stops the program with an
+/		AssertionError when the values differ
+/
+/	read and write use one byte per word, unless SyscallFlagPacked is set on the descriptor given to write,
+/	then two bytes are taken from each word, the low one first (the layout of dbp declarations)
+/
+/	programs embedding the vm add their own syscalls, or replace the ones above, with HandleSyscall, a handler reads its
+/	arguments with Register and leaves its result in a with SetRegister, errors it returns stop the program the way
+/	the errors of the builtin syscalls do, the syscall limits apply to them all the same
+/
+*/
+
+type SyscallHandler func(*CPU) error
+
+func (this *CPU) HandleSyscall(number uint16, handler SyscallHandler) {
+    this.syscalls[number] = handler
+}
+
+const (
+    SyscallErrorNotFound = 2
+    SyscallErrorIO = 5
+    SyscallErrorBadDescriptor = 9
+    SyscallErrorNoMemory = 12
+    SyscallErrorAccess = 13
+    SyscallErrorExists = 17
+    SyscallErrorInvalid = 22
+    SyscallErrorTooManyFiles = 24
+    SyscallErrorNameTooLong = 36
+)
+
+func SyscallError(code int) uint16 {
+    return uint16(-code)
+}
+
+type AssertionError struct {
+    actual, expected uint16
+    message string
+}
+
+func (this *AssertionError) Error() string {
+    text := fmt.Sprintf("assertion failed: got %d, expected %d", this.actual, this.expected)
+
+    if this.message != "" {
+	text += ": " + this.message
+    }
+
+    return text
+}
+
+func (this *CPU) SyscallAssert() error {
+    if this.b == this.c {
+	this.a = 0
+	return nil
+    }
+
+    var message string
+
+    if this.d != 0 {
+	var err error
+
+	/* a message without its nul is shown cut */
+	if message, err = this.ReadString(this.d); err != nil && !errors.Is(err, ErrStringTooLong) {
+	    return err
+	}
+    }
+
+    return &AssertionError{this.b, this.c, message}
+}
+
+func (this *CPU) SyscallWrite() error {
+    descriptor, packed := this.b &^ SyscallFlagPacked, this.b & SyscallFlagPacked != 0x0000
+    var writer io.Writer
+
+    switch descriptor {
+    case 1:
+	writer = this.stdout
+
+    case 2:
+	writer = this.stderr
+
+    default:
+	if file := this.File(descriptor); file != nil {
+	    writer = file
+	} else {
+	    this.a = SyscallError(SyscallErrorBadDescriptor)
+	    return nil
+	}
+    }
+
+    if err := this.CheckOutput(uint64(this.d)); err != nil {
+	return err
+    }
+
+    buffer := make([]byte, this.d)
+
+    for i := 0; i < int(this.d); i++ {
+	address := this.c + uint16(i)
+
+	if packed {
+	    address = this.c + uint16(i / 2)
+	}
+
+	word, err := this.ReadMemory(address)
+
+	if err != nil {
+	    return err
+	}
+
+	if packed && i % 2 == 1 {
+	    word >>= 8
+	}
+
+	buffer[i] = byte(word)
+    }
+
+    count, err := writer.Write(buffer)
+
+    if err != nil {
+	this.a = FileError(err)
+    } else {
+	this.a = uint16(count)
+    }
+
+    return nil
+}
@@ -0,0 +1,120 @@
+package vm
+
+import "errors"
+
+const (
+	TimerAddress = 0xff00
+
+	TimerRegisterControl = 0
+	TimerRegisterReload  = 1
+	TimerRegisterCounter = 2
+	TimerRegisterCount   = 3
+
+	TimerControlEnabled   = 0x0001
+	TimerControlInterrupt = 0x0002
+
+	TimerInterruptLine = 0
+)
+
+/*
+/
+/ Timer:
+/	counts executed instructions, so runs are deterministic, not wall clock time
+/
+/	registers (memory mapped from TimerAddress):
+/		+0 control: [interrupt, enabled]
+/		+1 reload:  the count at which the timer fires, writing it restarts the count
+/		+2 counter: instructions counted since the last fire
+/
+/	when enabled and counter reaches reload, counter goes back to 0 and, if the interrupt bit is set,
+/	interrupt line TimerInterruptLine is raised (vector InterruptVectorLineBase + TimerInterruptLine)
+/
+/	setting up a periodic interrupt every 100 instructions:
+/		mov a, 65281	// TimerAddress + 1
+/		str a, 100
+/		dec a
+/		str a, 3
+/		sti
+/
+*/
+
+type Timer struct {
+	control, reload, counter uint16
+}
+
+func NewTimer() Timer {
+	return Timer{0, 0, 0}
+}
+
+func (this *Timer) Read(register uint16) (uint16, error) {
+	switch register {
+	case TimerRegisterControl:
+		return this.control, nil
+
+	case TimerRegisterReload:
+		return this.reload, nil
+
+	case TimerRegisterCounter:
+		return this.counter, nil
+
+	default:
+		return 0, errors.New("timer register out of bounds")
+	}
+}
+
+func (this *Timer) Write(register, value uint16) error {
+	switch register {
+	case TimerRegisterControl:
+		this.control = value
+
+	case TimerRegisterReload:
+		this.reload = value
+		this.counter = 0
+
+	case TimerRegisterCounter:
+		this.counter = value
+
+	default:
+		return errors.New("timer register out of bounds")
+	}
+
+	return nil
+}
+
+/* called once per executed instruction */
+func (this *Timer) Tick(cpu *CPU) {
+	if this.control&TimerControlEnabled == 0x0000 || this.reload == 0 {
+		return
+	}
+
+	this.counter++
+
+	if this.counter >= this.reload {
+		this.counter = 0
+
+		if this.control&TimerControlInterrupt != 0x0000 {
+			cpu.RaiseInterrupt(TimerInterruptLine)
+		}
+	}
+}
+
+func (this *Timer) Snapshot() []uint16 {
+	return []uint16{this.control, this.reload, this.counter}
+}
+
+func (this *Timer) Validate(state []uint16) error {
+	if len(state) != TimerRegisterCount {
+		return errors.New("failed to restore timer: invalid state size")
+	}
+
+	return nil
+}
+
+func (this *Timer) Restore(state []uint16) error {
+	if err := this.Validate(state); err != nil {
+		return err
+	}
+
+	this.control, this.reload, this.counter = state[0], state[1], state[2]
+	return nil
+}
@@ -0,0 +1,188 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VideoScreenWidth  = 256
+	VideoScreenHeight = 240
+	VideoMemorySize   = VideoScreenWidth * VideoScreenHeight
+
+	GPUAddress = 0xff08
+
+	GPURegisterControl = 0
+	GPURegisterFrames  = 1
+	GPURegisterCount   = 2
+
+	GPUControlPresent = 0x0001
+)
+
+/*
+/
+/ Video:
+/	video memory is 256 by 240 pixels (the nes resolution), one word per pixel in rgb565:
+/		[red: 5 bits, green: 6 bits, blue: 5 bits]
+/	pixel (x, y) is at index y * 256 + x, it is not mapped on the bus, vld and vst access it instead
+/
+/	gpu registers (memory mapped from GPUAddress):
+/		+0 control: writing the present bit outputs the current frame
+/		+1 frames:  number of frames presented so far, its low 16 bits (read only)
+/
+/	output is headless, frames are written to a directory as frame_000000.ppm (or .png),
+/	either when presented or every n executed instructions, nothing is written when no directory is set,
+/	written frames count against the output limit (see limits.go)
+/
+*/
+
+type GPU struct {
+	memory            []uint16
+	directory, format string
+	every, steps      uint64
+	frames            uint64 // names the frame files, so they never wrap around
+	charge            func(count uint64) error // counts the written bytes against the output limit
+}
+
+func NewGPU(memory []uint16, charge func(count uint64) error) GPU {
+	return GPU{memory, "", "ppm", 0, 0, 0, charge}
+}
+
+/* format is either "ppm" or "png", every is 0 to only output presented frames */
+func (this *GPU) SetOutput(directory, format string, every uint64) error {
+	if format != "ppm" && format != "png" {
+		return errors.New("unsupported frame format: " + format)
+	}
+
+	this.directory, this.format, this.every = directory, format, every
+	return nil
+}
+
+func (this *GPU) Frame() *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, VideoScreenWidth, VideoScreenHeight))
+
+	for index, pixel := range this.memory {
+		red := uint8((pixel >> 11 & 0x1f) * 255 / 0x1f)
+		green := uint8((pixel >> 5 & 0x3f) * 255 / 0x3f)
+		blue := uint8((pixel & 0x1f) * 255 / 0x1f)
+		frame.SetRGBA(index%VideoScreenWidth, index/VideoScreenWidth, color.RGBA{red, green, blue, 0xff})
+	}
+
+	return frame
+}
+
+func (this *GPU) Present() error {
+	this.frames++
+
+	if this.directory == "" {
+		return nil
+	}
+
+	/* encoded in memory first, the frame is only written when it fits in the output limit */
+	var encoded bytes.Buffer
+	var err error
+
+	if this.format == "png" {
+		err = png.Encode(&encoded, this.Frame())
+	} else {
+		err = this.WritePPM(&encoded)
+	}
+
+	if err == nil {
+		err = this.charge(uint64(encoded.Len()))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(this.directory, fmt.Sprintf("frame_%06d.%s", this.frames-1, this.format))
+	return os.WriteFile(path, encoded.Bytes(), 0644)
+}
+
+func (this *GPU) WritePPM(output io.Writer) error {
+	writer := bufio.NewWriter(output)
+	frame := this.Frame()
+	fmt.Fprintf(writer, "P6\n%d %d\n255\n", VideoScreenWidth, VideoScreenHeight)
+
+	for index := 0; index < len(frame.Pix); index += 4 {
+		writer.Write(frame.Pix[index : index+3])
+	}
+
+	return writer.Flush()
+}
+
+/* called once per executed instruction */
+func (this *GPU) Tick() error {
+	if this.every == 0 {
+		return nil
+	}
+
+	this.steps++
+
+	if this.steps%this.every == 0 {
+		return this.Present()
+	}
+
+	return nil
+}
+
+func (this *GPU) Read(register uint16) (uint16, error) {
+	switch register {
+	case GPURegisterControl:
+		return 0, nil
+
+	case GPURegisterFrames:
+		return uint16(this.frames), nil
+
+	default:
+		return 0, errors.New("gpu register out of bounds")
+	}
+}
+
+func (this *GPU) Write(register, value uint16) error {
+	switch register {
+	case GPURegisterControl:
+		if value&GPUControlPresent != 0x0000 {
+			return this.Present()
+		}
+
+		return nil
+
+	case GPURegisterFrames:
+		return errors.New("gpu frames register is read only")
+
+	default:
+		return errors.New("gpu register out of bounds")
+	}
+}
+
+/* only the frame counter, the pixels live in video memory and the output is configured again */
+func (this *GPU) Snapshot() []uint16 {
+	return SnapshotCounterWords(this.frames)
+}
+
+func (this *GPU) Validate(state []uint16) error {
+	if len(state) != 4 {
+		return errors.New("failed to restore gpu: invalid state size")
+	}
+
+	return nil
+}
+
+func (this *GPU) Restore(state []uint16) error {
+	if err := this.Validate(state); err != nil {
+		return err
+	}
+
+	this.frames = SnapshotCounter(state)
+	return nil
+}
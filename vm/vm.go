@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"io"
+	"os"
+)
+
+/*
+/
+/ Embedding:
+/	New builds a cpu for programs that run the vm themselves rather than through the nfasm command:
+/
+/		cpu, err := vm.New(vm.Options{Stdout: &output, Limits: vm.Limits{MaxSteps: 100000}})
+/		err = cpu.LoadProgramFromMemory(program.Words())
+/		err = cpu.Run(ctx, []string{"program"})
+/
+/	Run sets up the program entry and runs it until it stops, Start and Cycle do the same one step at a time, Register,
+/	ReadMemory and their setters inspect the machine in between, HandleSyscall adds syscalls (see syscalls.go) and
+/	Instructions holds the function behind every opcode
+/
+/	the zero value of each option keeps the default of NewCPU: the process stdin, stdout and stderr, no environment,
+/	no sandbox and no limits
+/
+*/
+
+type Options struct {
+	Debug          bool
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+	Environment    []string
+	Sandbox        string
+	Limits         Limits
+}
+
+func New(options Options) (*CPU, error) {
+	cpu := NewCPU(options.Debug)
+
+	if options.Stdin != nil {
+		cpu.SetInput(options.Stdin)
+	}
+
+	if options.Stdout != nil || options.Stderr != nil {
+		stdout, stderr := options.Stdout, options.Stderr
+
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+
+		if stderr == nil {
+			stderr = os.Stderr
+		}
+
+		cpu.SetOutput(stdout, stderr)
+	}
+
+	if err := cpu.SetSandbox(options.Sandbox); err != nil {
+		return nil, err
+	}
+
+	cpu.SetEnvironment(options.Environment)
+	cpu.SetLimits(options.Limits)
+	return cpu, nil
+}